@@ -0,0 +1,94 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+/* compression of a stored body, recorded per html_blobs row */
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+)
+
+/* gzip a body for storage, returns the data and its compression */
+func CompressBody(body []byte) ([]byte, string, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), CompressionGzip, nil
+}
+
+/* original bytes of a stored body */
+func DecompressBody(data []byte, compression string) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return io.ReadAll(gz)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+/* one-off: compress blobs stored before compression, returns how many */
+func CompressBlobs(db *sql.DB, batchSize int) (int, error) {
+	total := 0
+	for {
+		rows, err := db.Query(
+			`SELECT hash, body FROM html_blobs WHERE compression = $1 LIMIT $2`,
+			CompressionNone, batchSize,
+		)
+		if err != nil {
+			return total, err
+		}
+		type blob struct {
+			hash string
+			body []byte
+		}
+		var batch []blob
+		for rows.Next() {
+			var b blob
+			if err := rows.Scan(&b.hash, &b.body); err != nil {
+				rows.Close()
+				return total, err
+			}
+			batch = append(batch, b)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, b := range batch {
+			data, compression, err := CompressBody(b.body)
+			if err != nil {
+				return total, err
+			}
+			_, err = db.Exec(
+				`UPDATE html_blobs SET body = $2, compression = $3 WHERE hash = $1 AND compression = $4`,
+				b.hash, data, compression, CompressionNone,
+			)
+			if err != nil {
+				return total, err
+			}
+			total++
+		}
+	}
+}
@@ -22,13 +22,9 @@ func MigrateDatabase(db *sql.DB) error {
 	return err
 }
 
-/* NEUE separate Migration für raw_html */
+/* NEUE separate Migration für raw_html, kept across restarts so crawls can resume */
 func MigrateRawHTML(db *sql.DB) error {
-	_, err := db.Exec(`DROP TABLE IF EXISTS raw_html`)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(`
+	_, err := db.Exec(`
         CREATE TABLE IF NOT EXISTS raw_html (
             id SERIAL PRIMARY KEY,
             url TEXT,
@@ -36,8 +32,267 @@ func MigrateRawHTML(db *sql.DB) error {
             concurrency INT,
             html BYTEA,
             totalResults INT,
-            completed_at TIMESTAMP
+            completed_at TIMESTAMP,
+            status_code INT,
+            headers JSONB,
+            content_type TEXT,
+            final_url TEXT,
+            redirect_chain JSONB,
+            response_time_ms BIGINT,
+            byte_size BIGINT,
+            request_headers JSONB,
+            proxy TEXT,
+            truncated BOOLEAN NOT NULL DEFAULT FALSE,
+            encoding TEXT
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
+	// tables created before the response metadata columns
+	_, err = db.Exec(`
+        ALTER TABLE raw_html
+            ADD COLUMN IF NOT EXISTS status_code INT,
+            ADD COLUMN IF NOT EXISTS headers JSONB,
+            ADD COLUMN IF NOT EXISTS content_type TEXT,
+            ADD COLUMN IF NOT EXISTS final_url TEXT,
+            ADD COLUMN IF NOT EXISTS redirect_chain JSONB,
+            ADD COLUMN IF NOT EXISTS response_time_ms BIGINT,
+            ADD COLUMN IF NOT EXISTS byte_size BIGINT,
+            ADD COLUMN IF NOT EXISTS request_headers JSONB,
+            ADD COLUMN IF NOT EXISTS proxy TEXT,
+            ADD COLUMN IF NOT EXISTS truncated BOOLEAN NOT NULL DEFAULT FALSE,
+            ADD COLUMN IF NOT EXISTS encoding TEXT
+    `)
+	return err
+}
+
+/* skipped urls with reason (robots.txt etc.) */
+func MigrateSkippedURLs(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS skipped_urls (
+            id SERIAL PRIMARY KEY,
+            url TEXT NOT NULL,
+            reason TEXT NOT NULL,
+            skipped_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	return err
+}
+
+/* every request attempt, to tell flaky hosts from dead ones */
+func MigrateFetchAttempts(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS fetch_attempts (
+            id SERIAL PRIMARY KEY,
+            url TEXT NOT NULL,
+            attempt INT NOT NULL,
+            status_code INT,
+            error TEXT,
+            duration_ms BIGINT,
+            wait_ms BIGINT,
+            proxy TEXT,
+            attempted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	return err
+}
+
+/* cookie jars persisted between runs of a session */
+func MigrateSessionCookies(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS session_cookies (
+            session TEXT NOT NULL,
+            domain TEXT NOT NULL,
+            path TEXT NOT NULL,
+            name TEXT NOT NULL,
+            value TEXT NOT NULL,
+            host_only BOOLEAN NOT NULL DEFAULT FALSE,
+            secure BOOLEAN NOT NULL DEFAULT FALSE,
+            http_only BOOLEAN NOT NULL DEFAULT FALSE,
+            expires TIMESTAMP,
+            PRIMARY KEY (session, domain, path, name)
         )
     `)
 	return err
 }
+
+/* crawls and their persisted frontier / visited set */
+func MigrateCrawls(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS crawls (
+            id SERIAL PRIMARY KEY,
+            start_url TEXT NOT NULL,
+            max_depth INT NOT NULL,
+            options JSONB NOT NULL DEFAULT '{}',
+            state TEXT NOT NULL DEFAULT 'running',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS crawl_frontier (
+            id BIGSERIAL PRIMARY KEY,
+            crawl_id INT NOT NULL REFERENCES crawls(id) ON DELETE CASCADE,
+            url TEXT NOT NULL,
+            depth INT NOT NULL,
+            state TEXT NOT NULL DEFAULT 'queued',
+            error TEXT,
+            claimed_at TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (crawl_id, url)
+        )
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS crawl_frontier_claim_idx ON crawl_frontier (crawl_id, state, depth, id)`)
+	return err
+}
+
+/* ETag / Last-Modified and Cache-Control freshness per url and request variant (headers sent) */
+func MigrateHTTPCache(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS http_cache (
+            url TEXT NOT NULL,
+            variant TEXT NOT NULL DEFAULT '',
+            etag TEXT,
+            last_modified TEXT,
+            final_url TEXT,
+            content_hash TEXT,
+            expires_at TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (url, variant)
+        )
+    `)
+	return err
+}
+
+/* bodies stored once per sha256 (of the uncompressed body), raw_html rows reference them by content_hash */
+func MigrateHTMLBlobs(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS html_blobs (
+            hash TEXT PRIMARY KEY,
+            body BYTEA NOT NULL,
+            size BIGINT NOT NULL,
+            compression TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE html_blobs ADD COLUMN IF NOT EXISTS compression TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+        ALTER TABLE raw_html
+            ADD COLUMN IF NOT EXISTS content_hash TEXT REFERENCES html_blobs(hash),
+            ADD COLUMN IF NOT EXISTS changed BOOLEAN
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS raw_html_url_idx ON raw_html (url, id)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS raw_html_content_hash_idx ON raw_html (content_hash, id)`)
+	if err != nil {
+		return err
+	}
+
+	// move bodies of older rows into the blob table, compressed later by -compress-blobs
+	_, err = db.Exec(`
+        INSERT INTO html_blobs (hash, body, size)
+        SELECT DISTINCT ON (encode(sha256(html), 'hex')) encode(sha256(html), 'hex'), html, octet_length(html)
+        FROM raw_html WHERE content_hash IS NULL AND html IS NOT NULL
+        ON CONFLICT (hash) DO NOTHING
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+        UPDATE raw_html SET content_hash = encode(sha256(html), 'hex'), html = NULL
+        WHERE content_hash IS NULL AND html IS NOT NULL
+    `)
+	return err
+}
+
+/* bulk requests with their lifecycle and counters, raw_html rows and crawls link to them */
+func MigrateScrapeJobs(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS scrape_jobs (
+            id SERIAL PRIMARY KEY,
+            request JSONB NOT NULL,
+            state TEXT NOT NULL DEFAULT 'queued',
+            error TEXT,
+            fetched BIGINT NOT NULL DEFAULT 0,
+            failed BIGINT NOT NULL DEFAULT 0,
+            skipped BIGINT NOT NULL DEFAULT 0,
+            bytes BIGINT NOT NULL DEFAULT 0,
+            cache_hits BIGINT NOT NULL DEFAULT 0,
+            cache_revalidated BIGINT NOT NULL DEFAULT 0,
+            cache_misses BIGINT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            started_at TIMESTAMP,
+            finished_at TIMESTAMP,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE raw_html ADD COLUMN IF NOT EXISTS job_id INT REFERENCES scrape_jobs(id) ON DELETE SET NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE crawls ADD COLUMN IF NOT EXISTS job_id INT REFERENCES scrape_jobs(id) ON DELETE SET NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS raw_html_job_idx ON raw_html (job_id)`)
+	return err
+}
+
+/* durable work queue shared by server and worker processes */
+func MigrateTaskQueue(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS task_queue (
+            id BIGSERIAL PRIMARY KEY,
+            kind TEXT NOT NULL,
+            job_id INT REFERENCES scrape_jobs(id) ON DELETE CASCADE,
+            weight INT NOT NULL DEFAULT 1,
+            payload JSONB NOT NULL,
+            state TEXT NOT NULL DEFAULT 'pending',
+            attempts INT NOT NULL DEFAULT 0,
+            max_attempts INT NOT NULL,
+            available_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            lease_until TIMESTAMP,
+            worker TEXT,
+            last_error TEXT,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS task_queue_claim_idx ON task_queue (state, available_at, id)`)
+	if err != nil {
+		return err
+	}
+	// one open task per job, so two workers never run the same job
+	_, err = db.Exec(`
+        CREATE UNIQUE INDEX IF NOT EXISTS task_queue_open_job_idx ON task_queue (job_id)
+        WHERE state IN ('pending', 'leased')
+    `)
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+
+	"webScraper/parser"
 )
 
 type ParsedResults struct {
@@ -84,7 +86,7 @@ func (p *Postgres) CountParsedResults() (int, error) {
 
 /* rawhtml to parsedResults */
 func (p *Postgres) ProcessRawHTML(parseFunc func(url string, html []byte) (price, title string, completed_at sql.NullTime)) error {
-    rows, err := p.DB.Query("SELECT url, html, completed_at FROM raw_html")
+    rows, err := p.DB.Query("SELECT url, " + RawHTMLBody + ", " + RawHTMLCompression + ", COALESCE(encoding, ''), completed_at FROM " + RawHTMLSource)
     if err != nil {
         return err
     }
@@ -93,10 +95,19 @@ func (p *Postgres) ProcessRawHTML(parseFunc func(url string, html []byte) (price
     for rows.Next() {
         var url string
         var html []byte
+        var compression, encoding string
         var dbCompletedAt sql.NullTime
-        if err := rows.Scan(&url, &html, &dbCompletedAt); err != nil {
+        if err := rows.Scan(&url, &html, &compression, &encoding, &dbCompletedAt); err != nil {
             return err
         }
+        html, err := DecompressBody(html, compression)
+        if err != nil {
+            return fmt.Errorf("raw html %s: %w", url, err)
+        }
+        // stored bytes are the original encoding, parsers get UTF-8
+        if utf8HTML, err := parser.ToUTF8(html, encoding); err == nil {
+            html = utf8HTML
+        }
         price, title, completedAt := parseFunc(url, html)
         if err := p.InputParsedResults(url, price, title, completedAt); err != nil {
             return err
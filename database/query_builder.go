@@ -1,10 +1,27 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
+
+	"github.com/lib/pq"
 )
 
+/* raw_html columns returned by the query endpoints */
+const RawHTMLColumns = `id, url, COALESCE(max_pages, 0), COALESCE(concurrency, 0), COALESCE(totalresults, 0), completed_at,
+    COALESCE(status_code, 0), COALESCE(headers, '{}'), COALESCE(content_type, ''), COALESCE(final_url, ''),
+    COALESCE(redirect_chain, '[]'), COALESCE(response_time_ms, 0), COALESCE(byte_size, 0), COALESCE(encoding, ''),
+    COALESCE(content_hash, ''), changed`
+
+/* raw_html with its deduplicated body */
+const RawHTMLSource = `raw_html LEFT JOIN html_blobs ON html_blobs.hash = raw_html.content_hash`
+
+/* body of a RawHTMLSource row, older rows still have it inline; decompress with DecompressBody */
+const RawHTMLBody = `COALESCE(html_blobs.body, raw_html.html)`
+
+const RawHTMLCompression = `COALESCE(html_blobs.compression, '')`
+
 type QueryBuilder struct {
 	Links		[]string
 	Keywords 	[]string
@@ -12,52 +29,78 @@ type QueryBuilder struct {
 	DateTo		string
 	SortBy		string
 	Limit		int	
+	StatusCodes	[]int
+	ContentType	string
+	MinSize		int64
+	MaxSize		int64
+	Redirected	string
+	Changed		string
+	ScanLimit	int
+	ScanOffset	int
 }
 
-/* all rawhtmlquery functions combined */
+/* all rawhtmlquery functions combined; with body filters it also selects
+   RawHTMLBody and RawHTMLCompression of a batch of ScanRows rows from ScanOffset on
+   and leaves matching to MatchBody */
 func (qb *QueryBuilder) BuildRawHTMLQuery() string {
     var whereClauses []string
 
-    if links := qb.FilterLinks(); links != "" {
-        whereClauses = append(whereClauses, links)
-    }
-    if keywords := qb.FilterKeywords(); keywords != "" {
-        whereClauses = append(whereClauses, keywords)
-    }
     if date := qb.FilterDate(); date != "" {
         whereClauses = append(whereClauses, date)
     }
+    if response := qb.FilterResponse(); response != "" {
+        whereClauses = append(whereClauses, response)
+    }
 
     where := ""
     if len(whereClauses) > 0 {
-        where = "WHERE " + strings.Join(whereClauses, " AND ")
+        where = "WHERE (" + strings.Join(whereClauses, ") AND (") + ")"
+    }
+
+    columns, sort, limit := RawHTMLColumns, qb.Sort(), qb.LimitClause()
+    if qb.HasBodyFilters() {
+        // bodies are compressed, so links and keywords are matched after decompressing
+        columns += ", " + RawHTMLBody + ", " + RawHTMLCompression
+        limit = fmt.Sprintf("LIMIT %d OFFSET %d", qb.ScanRows(), qb.ScanOffset)
+        // batches page through the rows, ties need a fixed order
+        if sort == "" {
+            sort = "ORDER BY raw_html.id DESC"
+        } else {
+            sort += ", raw_html.id DESC"
+        }
     }
 
     query := fmt.Sprintf(
-        "SELECT * FROM raw_html %s %s %s;",
+        "SELECT %s FROM %s %s %s %s;",
+        columns,
+        RawHTMLSource,
         where,
-        qb.Sort(),
-        qb.LimitClause(),
+        sort,
+        limit,
     )
     return query
 }
 
-/* filter for links */
-func (qb *QueryBuilder) FilterLinks() string {
-	var conditions []string
-	for _, link := range qb.Links {
-		conditions = append(conditions, fmt.Sprintf("html Like '%%%s%%'", link))
-	}
-	return strings.Join(conditions, " OR ")
+/* links or keywords to look for in the bodies */
+func (qb *QueryBuilder) HasBodyFilters() bool {
+    return len(qb.Links) > 0 || len(qb.Keywords) > 0
 }
 
-/* filter for keywords */
-func (qb *QueryBuilder) FilterKeywords() string {
-    var conditions []string
-    for _, kw := range qb.Keywords {
-        conditions = append(conditions, fmt.Sprintf("html LIKE '%%%s%%'", kw))
+/* any of the links and any of the keywords in a decompressed body */
+func (qb *QueryBuilder) MatchBody(body []byte) bool {
+    return containsAny(body, qb.Links) && containsAny(body, qb.Keywords)
+}
+
+func containsAny(body []byte, terms []string) bool {
+    if len(terms) == 0 {
+        return true
+    }
+    for _, t := range terms {
+        if bytes.Contains(body, []byte(t)) {
+            return true
+        }
     }
-    return strings.Join(conditions, " OR ")
+    return false
 }
 
 /* filter date */
@@ -68,21 +111,82 @@ func (qb *QueryBuilder) FilterDate() string {
     return ""
 }
 
+/* listing of completed scrapes, filtered on response metadata */
+func (qb *QueryBuilder) BuildScrapesQuery() string {
+    where := ""
+    if response := qb.FilterResponse(); response != "" {
+        where = "WHERE " + response
+    }
+    // rows stored before completed_at was set have it NULL
+    return fmt.Sprintf("SELECT %s FROM raw_html %s ORDER BY completed_at DESC NULLS LAST, id DESC;", RawHTMLColumns, where)
+}
+
+/* filter status, content type, size, redirects and changed bodies */
+func (qb *QueryBuilder) FilterResponse() string {
+    var conditions []string
+    if len(qb.StatusCodes) > 0 {
+        codes := make([]string, len(qb.StatusCodes))
+        for i, c := range qb.StatusCodes {
+            codes[i] = fmt.Sprintf("%d", c)
+        }
+        conditions = append(conditions, fmt.Sprintf("status_code IN (%s)", strings.Join(codes, ", ")))
+    }
+    if qb.ContentType != "" {
+        conditions = append(conditions, "content_type ILIKE "+pq.QuoteLiteral(qb.ContentType+"%"))
+    }
+    if qb.MinSize > 0 {
+        conditions = append(conditions, fmt.Sprintf("byte_size >= %d", qb.MinSize))
+    }
+    if qb.MaxSize > 0 {
+        conditions = append(conditions, fmt.Sprintf("byte_size <= %d", qb.MaxSize))
+    }
+    switch qb.Redirected {
+    case "true":
+        conditions = append(conditions, "jsonb_array_length(redirect_chain) > 0")
+    case "false":
+        conditions = append(conditions, "jsonb_array_length(redirect_chain) = 0")
+    }
+    switch qb.Changed {
+    case "true":
+        conditions = append(conditions, "changed")
+    case "false":
+        conditions = append(conditions, "NOT changed")
+    }
+    return strings.Join(conditions, " AND ")
+}
+
 /* sort by date & bytesize */
 func (qb *QueryBuilder) Sort() string {
     switch qb.SortBy {
     case "date":
         return "ORDER BY completed_at DESC"
     case "size":
-        return "ORDER BY octet_length(html) DESC"
+        return "ORDER BY byte_size DESC"
+    case "status":
+        return "ORDER BY status_code DESC"
+    case "time":
+        return "ORDER BY response_time_ms DESC"
     default:
         return ""
     }
 }
 /* limit results */
 func (qb *QueryBuilder) LimitClause() string {
+    return fmt.Sprintf("LIMIT %d", qb.MaxRows())
+}
+
+/* row limit, 100 by default */
+func (qb *QueryBuilder) MaxRows() int {
     if qb.Limit > 0 {
-        return fmt.Sprintf("LIMIT %d", qb.Limit)
+        return qb.Limit
+    }
+    return 100
+}
+
+/* rows per batch whose bodies a body filter decompresses, 1000 by default */
+func (qb *QueryBuilder) ScanRows() int {
+    if qb.ScanLimit > 0 {
+        return qb.ScanLimit
     }
-    return "LIMIT 100" 
+    return 1000
 }
\ No newline at end of file
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+/* task_queue states */
+const (
+	QueuePending = "pending"
+	QueueLeased  = "leased"
+	QueueDone    = "done"
+	QueueDead    = "dead"
+)
+
+/* advisory lock key serializing Enqueue */
+const enqueueLock = 7301
+
+const pendingQuery = `SELECT COUNT(*), COALESCE(SUM(weight), 0) FROM task_queue WHERE state = $1`
+
+var (
+	ErrQueueFull = errors.New("queue full")
+	ErrLeaseLost = errors.New("queue lease lost")
+	ErrJobQueued = errors.New("job already queued or running on a worker")
+)
+
+/* a claimed task, owned by its worker until the lease runs out */
+type QueueTask struct {
+	ID          int64
+	Kind        string
+	JobID       sql.NullInt64
+	Payload     json.RawMessage
+	Attempts    int // including this one
+	MaxAttempts int
+}
+
+/* postgres backed queue, tasks of crashed workers come back once their lease expires */
+type Queue struct {
+	DB          *sql.DB
+	Lease       time.Duration // how long a claim holds without a heartbeat
+	MaxAttempts int           // claims before a task is dead-lettered
+	MaxPending  int           // weight of pending tasks Enqueue accepts, 0 means no limit
+	Backoff     time.Duration // delay before a retry, grows with the attempts
+}
+
+func NewQueue(db *sql.DB) *Queue {
+	return &Queue{
+		DB:          db,
+		Lease:       time.Minute,
+		MaxAttempts: 3,
+		MaxPending:  1000,
+		Backoff:     10 * time.Second,
+	}
+}
+
+/* add a task of weight units (urls), returns its id and queue position; ErrQueueFull, ErrJobQueued */
+func (q *Queue) Enqueue(kind string, jobID sql.NullInt64, weight int, payload any) (int64, int, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := q.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// one enqueue at a time across servers, the capacity check holds until commit
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, enqueueLock); err != nil {
+		return 0, 0, err
+	}
+	var tasks, pending int
+	if err := tx.QueryRow(pendingQuery, QueuePending).Scan(&tasks, &pending); err != nil {
+		return 0, 0, err
+	}
+	if !q.Fits(pending, weight) {
+		return 0, tasks + 1, ErrQueueFull
+	}
+
+	var id int64
+	err = tx.QueryRow(
+		`INSERT INTO task_queue (kind, job_id, weight, payload, state, max_attempts) VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (job_id) WHERE state IN ('pending', 'leased') DO NOTHING
+        RETURNING id`,
+		kind, jobID, weight, raw, QueuePending, max(q.MaxAttempts, 1),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, tasks + 1, ErrJobQueued
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, tasks + 1, tx.Commit()
+}
+
+/* whether weight more units fit next to pending ones; an empty queue takes anything */
+func (q *Queue) Fits(pending, weight int) bool {
+	return q.MaxPending <= 0 || weight == 0 || pending == 0 || pending+weight <= q.MaxPending
+}
+
+/* tasks waiting for a worker and their total weight */
+func (q *Queue) Pending() (int, int, error) {
+	var tasks, weight int
+	err := q.DB.QueryRow(pendingQuery, QueuePending).Scan(&tasks, &weight)
+	return tasks, weight, err
+}
+
+/* dead-letter expired leases without attempts left, returns the jobs of those tasks */
+func (q *Queue) ExpireLeases() ([]int64, error) {
+	rows, err := q.DB.Query(
+		`UPDATE task_queue SET state = $1, last_error = COALESCE(last_error, 'lease expired'), updated_at = NOW()
+        WHERE state = $2 AND lease_until < NOW() AND attempts >= max_attempts
+        RETURNING job_id`,
+		QueueDead, QueueLeased,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobIDs []int64
+	for rows.Next() {
+		var jobID sql.NullInt64
+		if err := rows.Scan(&jobID); err != nil {
+			return nil, err
+		}
+		if jobID.Valid {
+			jobIDs = append(jobIDs, jobID.Int64)
+		}
+	}
+	return jobIDs, rows.Err()
+}
+
+/* take the oldest available task or one whose lease expired, nil when there is none */
+func (q *Queue) Claim(worker string) (*QueueTask, error) {
+	t := &QueueTask{}
+	err := q.DB.QueryRow(
+		`UPDATE task_queue SET state = $1, worker = $2, attempts = attempts + 1,
+            lease_until = NOW() + make_interval(secs => $3), updated_at = NOW()
+        WHERE id = (
+            SELECT id FROM task_queue
+            WHERE (state = $4 AND available_at <= NOW())
+                OR (state = $1 AND lease_until < NOW() AND attempts < max_attempts)
+            ORDER BY id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, kind, job_id, payload, attempts, max_attempts`,
+		QueueLeased, worker, q.Lease.Seconds(), QueuePending,
+	).Scan(&t.ID, &t.Kind, &t.JobID, &t.Payload, &t.Attempts, &t.MaxAttempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+/* extend the lease, ErrLeaseLost once another worker took the task over */
+func (q *Queue) Heartbeat(t *QueueTask, worker string) error {
+	return q.update(t, worker,
+		`UPDATE task_queue SET lease_until = NOW() + make_interval(secs => $4), updated_at = NOW()
+        WHERE id = $1 AND worker = $2 AND state = $3`,
+		q.Lease.Seconds(),
+	)
+}
+
+/* task finished, successfully or for good */
+func (q *Queue) Ack(t *QueueTask, worker string) error {
+	return q.update(t, worker,
+		`UPDATE task_queue SET state = $4, lease_until = NULL, updated_at = NOW()
+        WHERE id = $1 AND worker = $2 AND state = $3`,
+		QueueDone,
+	)
+}
+
+/* task failed, retried after a backoff or dead-lettered when out of attempts; reports the latter */
+func (q *Queue) Nack(t *QueueTask, worker, errMsg string) (bool, error) {
+	dead := t.Attempts >= t.MaxAttempts
+	state := QueuePending
+	if dead {
+		state = QueueDead
+	}
+	delay := q.Backoff * time.Duration(t.Attempts*t.Attempts)
+	err := q.update(t, worker,
+		`UPDATE task_queue SET state = $4, last_error = $5, lease_until = NULL, worker = NULL,
+            available_at = NOW() + make_interval(secs => $6), updated_at = NOW()
+        WHERE id = $1 AND worker = $2 AND state = $3`,
+		state, errMsg, delay.Seconds(),
+	)
+	return dead, err
+}
+
+/* hand the task back untouched after delay, e.g. on shutdown; the attempt doesn't count */
+func (q *Queue) Release(t *QueueTask, worker string, delay time.Duration) error {
+	return q.update(t, worker,
+		`UPDATE task_queue SET state = $4, attempts = GREATEST(attempts - 1, 0), lease_until = NULL, worker = NULL,
+            available_at = NOW() + make_interval(secs => $5), updated_at = NOW()
+        WHERE id = $1 AND worker = $2 AND state = $3`,
+		QueuePending, delay.Seconds(),
+	)
+}
+
+/* whether the job has a task waiting or being worked on */
+func (q *Queue) HasOpenTask(jobID int) (bool, error) {
+	var exists bool
+	err := q.DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM task_queue WHERE job_id = $1 AND state IN ($2, $3))`,
+		jobID, QueuePending, QueueLeased,
+	).Scan(&exists)
+	return exists, err
+}
+
+/* run the job's last finished task of kind again, false if there is none */
+func (q *Queue) Requeue(jobID int, kind string) (bool, error) {
+	res, err := q.DB.Exec(
+		`UPDATE task_queue SET state = $1, attempts = 0, worker = NULL, lease_until = NULL, last_error = NULL,
+            available_at = NOW(), updated_at = NOW()
+        WHERE id = (SELECT MAX(id) FROM task_queue WHERE job_id = $2 AND kind = $3) AND state IN ($4, $5)`,
+		QueuePending, jobID, kind, QueueDone, QueueDead,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+/* number of tasks per state */
+func (q *Queue) Counts() (map[string]int, error) {
+	rows, err := q.DB.Query(`SELECT state, COUNT(*) FROM task_queue GROUP BY state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{QueuePending: 0, QueueLeased: 0, QueueDone: 0, QueueDead: 0}
+	for rows.Next() {
+		var state string
+		var n int
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, err
+		}
+		counts[state] = n
+	}
+	return counts, rows.Err()
+}
+
+/* update a task the worker still holds */
+func (q *Queue) update(t *QueueTask, worker, query string, args ...any) error {
+	res, err := q.DB.Exec(query, append([]any{t.ID, worker, QueueLeased}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"webScraper/scraper"
+)
+
+type crawlStatus struct {
+	ID        int            `json:"id"`
+	StartURL  string         `json:"start_url"`
+	MaxDepth  int            `json:"max_depth"`
+	State     string         `json:"state"`
+	Active    bool           `json:"active"` // workers running in this process
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Frontier  map[string]int `json:"frontier"` // url count per frontier state
+	URLs      []frontierURL  `json:"urls,omitempty"`
+}
+
+type frontierURL struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+/* GET /api/crawls/{id}, ?list=<state> adds up to limit urls in that state */
+func CrawlHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid crawl id", http.StatusBadRequest)
+			return
+		}
+
+		c := crawlStatus{ID: id, Frontier: map[string]int{}, Active: scraper.CrawlActive(id)}
+		err = db.QueryRow(`SELECT start_url, max_depth, state, created_at, updated_at FROM crawls WHERE id = $1`, id).
+			Scan(&c.StartURL, &c.MaxDepth, &c.State, &c.CreatedAt, &c.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Crawl not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(`SELECT state, COUNT(*) FROM crawl_frontier WHERE crawl_id = $1 GROUP BY state`, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var state string
+			var n int
+			if err := rows.Scan(&state, &n); err != nil {
+				http.Error(w, "Scan error", http.StatusInternalServerError)
+				return
+			}
+			c.Frontier[state] = n
+		}
+
+		if state := r.URL.Query().Get("list"); state != "" {
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if limit <= 0 || limit > 1000 {
+				limit = 100
+			}
+			if c.URLs, err = frontierURLs(db, id, state, limit); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+func frontierURLs(db *sql.DB, crawlID int, state string, limit int) ([]frontierURL, error) {
+	rows, err := db.Query(
+		`SELECT url, depth, state, COALESCE(error, '') FROM crawl_frontier
+        WHERE crawl_id = $1 AND state = $2 ORDER BY depth, id LIMIT $3`,
+		crawlID, state, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []frontierURL{}
+	for rows.Next() {
+		var u frontierURL
+		if err := rows.Scan(&u.URL, &u.Depth, &u.State, &u.Error); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+/* POST /api/crawls/{id}/pause and /resume */
+func CrawlStateHandler(scraperInstance *scraper.Scraper, appCtx context.Context, state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid crawl id", http.StatusBadRequest)
+			return
+		}
+		if err := scraperInstance.SetCrawlState(id, state); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		// paused workers may still be finishing their pages; RunCrawl won't start twice
+		if state == scraper.CrawlRunning && !scraper.CrawlActive(id) {
+			go func() {
+				ctx, cancel := context.WithTimeout(appCtx, 10*time.Minute)
+				defer cancel()
+				if err := scraperInstance.ResumeCrawl(ctx, id); err != nil {
+					fmt.Fprintf(os.Stderr, "resume crawl %d: %v\n", id, err)
+				}
+			}()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%d,"state":%q}`, id, state)
+	}
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"webScraper/database"
@@ -28,24 +29,33 @@ func QueryHandler(db *sql.DB) http.HandlerFunc {
 			SortBy:   sortBy,
 			Limit:    limit,
 		}
+		parseResponseFilters(r, &qb)
 
-		query := qb.BuildRawHTMLQuery()
-			fmt.Println("SQL Query:", query)
-		rows, err := db.Query(query)
+		if qb.HasBodyFilters() {
+			results, err := queryMatchingRows(db, &qb)
+			if err != nil {
+				http.Error(w, "Query error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+
+		rows, err := db.Query(qb.BuildRawHTMLQuery())
 		if err != nil {
 			http.Error(w, "Query error", http.StatusInternalServerError)
 			return
 		}
 		defer rows.Close()
 
-		for rows.Next() {
-			var id int
-			var url string
-			if err := rows.Scan(&id, &url); err != nil {
-				http.Error(w, "Scan error", http.StatusInternalServerError)
-				return
-			}
-			fmt.Fprintln(w, "ID:", id, "URL:", url)
+		results, err := scanScrapeRows(rows)
+		if err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
 	}
 }
\ No newline at end of file
@@ -4,18 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"webScraper/database"
 	"webScraper/scraper"
+
+	"golang.org/x/net/http/httpguts"
 )
 
 type BulkScrapeRequest struct {
-	URLs    []string `json:"urls"`
-	Depth   int      `json:"depth"`
-	Keyword string   `json:"keyword"`
+	URLs           []string                  `json:"urls"`
+	Depth          int                       `json:"depth"`
+	Keyword        string                    `json:"keyword"`
+	Headers        map[string]string         `json:"headers"`
+	AcceptLanguage string                    `json:"accept_language"`
+	Referer        string                    `json:"referer"`
+	Session        string                    `json:"session"`
+	Cookies        []scraper.SessionCookie   `json:"cookies"`
+	CookiesTxt     string                    `json:"cookies_txt"`
+	CookiesFile    string                    `json:"cookies_file"`
+	Login          *scraper.LoginStep        `json:"login"`
+	TimeoutSeconds int                       `json:"timeout_seconds"`
+	Crawl          bool                      `json:"crawl"` // follow links depth levels down instead of paginating
+	Scope          *scraper.Scope            `json:"scope"`
+	MaxTotalPages  int                       `json:"max_total_pages"`
+	Sitemap        bool                      `json:"sitemap"`       // seed crawls with the sites' sitemap urls
+	SitemapSince   string                    `json:"sitemap_since"` // only urls with a newer lastmod, RFC 3339 or YYYY-MM-DD
+	Incremental    bool                      `json:"incremental"`   // sitemap_since defaults to the last finished crawl
+	Pagination     *scraper.PaginationConfig `json:"pagination"`
+	Cache          string                    `json:"cache"`                // off, revalidate (default) or prefer
+	TaskTimeout    int                       `json:"task_timeout_seconds"` // per page including retries
 }
 
 func BulkScrapeHandler(db *sql.DB, scraperInstance *scraper.Scraper, appCtx context.Context) http.HandlerFunc {
@@ -40,23 +67,267 @@ func BulkScrapeHandler(db *sql.DB, scraperInstance *scraper.Scraper, appCtx cont
 			req.Depth = 3 // default
 		}
 
-		// Start scraping in background
-		go func() {
-			ctx, cancel := context.WithTimeout(appCtx, 10*time.Minute)
-			defer cancel()
+		for name, value := range req.Headers {
+			if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+				http.Error(w, fmt.Sprintf("Invalid header %q", name), http.StatusBadRequest)
+				return
+			}
+		}
 
-			var wg sync.WaitGroup
+		cookies, err := requestCookies(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Login != nil {
+			if err := req.Login.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if req.Scope != nil {
+			if err := req.Scope.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if req.Pagination != nil {
+			if _, err := req.Pagination.Paginator(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch req.Cache {
+		case "", scraper.CacheOff, scraper.CacheRevalidate, scraper.CachePrefer:
+		default:
+			http.Error(w, fmt.Sprintf("Invalid cache mode %q", req.Cache), http.StatusBadRequest)
+			return
+		}
+
+		var since time.Time
+		if req.SitemapSince != "" {
+			if since, err = time.Parse(time.RFC3339, req.SitemapSince); err != nil {
+				if since, err = time.Parse(time.DateOnly, req.SitemapSince); err != nil {
+					http.Error(w, "Invalid sitemap_since", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if req.Sitemap {
+			// sitemap urls are crawl seeds
+			req.Crawl = true
+		}
+
+		jobScraper := scraperInstance.WithOptions(scraper.JobOptions{
+			Headers:        req.Headers,
+			AcceptLanguage: req.AcceptLanguage,
+			Referer:        req.Referer,
+			Session:        req.Session,
+			Cookies:        cookies,
+			Login:          req.Login,
+			Timeout:        time.Duration(req.TimeoutSeconds) * time.Second,
+			MaxTotalPages:  req.MaxTotalPages,
+			Scope:          req.Scope,
+			Pagination:     req.Pagination,
+			Cache:          req.Cache,
+			TaskTimeout:    time.Duration(req.TaskTimeout) * time.Second,
+		})
+
+		// refuse before anything is stored when the workers are too far behind
+		queue := scraperInstance.Queue
+		tasks, pending, err := queue.Pending()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !queue.Fits(pending, len(req.URLs)) {
+			queueFull(w, tasks+1, queue.MaxPending)
+			return
+		}
+
+		// stored without cookie values, those end up in the session jar
+		stored := req
+		stored.Cookies, stored.CookiesTxt = nil, ""
+		jobID, err := scraper.CreateJob(db, stored)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jobScraper = jobScraper.ForJob(jobID)
+		if err := jobScraper.PersistCookies(jobID); err != nil {
+			scraper.SetJobState(db, jobID, scraper.JobFailed, err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// crawls are stored up front so they can be inspected, paused and resumed
+		var crawlIDs []int
+		if req.Crawl {
 			for _, url := range req.URLs {
-				wg.Add(1)
-				go func(url string) {
-					defer wg.Done()
-					scraperInstance.Scrape(ctx, url, req.Depth)
-				}(url)
+				id, err := jobScraper.NewCrawl(url, req.Depth)
+				if err != nil {
+					scraper.SetJobState(db, jobID, scraper.JobFailed, err.Error())
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				crawlIDs = append(crawlIDs, id)
 			}
-			wg.Wait()
-		}()
+		}
+
+		// a worker runs the job, see BulkJobTask
+		_, position, err := queue.Enqueue(scraper.TaskBulkJob, sql.NullInt64{Int64: int64(jobID), Valid: true}, len(req.URLs), bulkTask{
+			JobID:    jobID,
+			Request:  stored,
+			Options:  jobScraper.Options,
+			CrawlIDs: crawlIDs,
+			Since:    since,
+		})
+		if err != nil {
+			scraper.SetJobState(db, jobID, scraper.JobFailed, err.Error())
+			if errors.Is(err, database.ErrQueueFull) {
+				queueFull(w, position, queue.MaxPending)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"status":"started","url_count":%d,"depth":%d}`, len(req.URLs), req.Depth)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":         "queued",
+			"job_id":         jobID,
+			"url_count":      len(req.URLs),
+			"depth":          req.Depth,
+			"crawl_ids":      crawlIDs,
+			"queue_position": position,
+		})
+	}
+}
+
+/* 429 with the position the job would have had */
+func queueFull(w http.ResponseWriter, position, maxQueued int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "30")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":          database.ErrQueueFull.Error(),
+		"queue_position": position,
+		"max_queued":     maxQueued,
+	})
+}
+
+/* queue payload of a bulk request, cookies are in the options' session */
+type bulkTask struct {
+	JobID    int                `json:"job_id"`
+	Request  BulkScrapeRequest  `json:"request"`
+	Options  scraper.JobOptions `json:"options"`
+	CrawlIDs []int              `json:"crawl_ids"`
+	Since    time.Time          `json:"since"`
+}
+
+/* run a bulk job a worker claimed, every url waits for the governor */
+func BulkJobTask(scraperInstance *scraper.Scraper) scraper.TaskFunc {
+	return func(ctx context.Context, t *database.QueueTask) error {
+		var task bulkTask
+		if err := json.Unmarshal(t.Payload, &task); err != nil {
+			return err
+		}
+		req, crawlIDs, since := task.Request, task.CrawlIDs, task.Since
+
+		// too much admitted already, the task goes back for another worker
+		governor := scraperInstance.Governor
+		if _, err := governor.Reserve(len(req.URLs)); err != nil {
+			return err
+		}
+		jobScraper := scraperInstance.WithOptions(task.Options).ForJob(task.JobID)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+		defer cancel()
+
+		// reservations of urls that never got to wait, e.g. when the login fails
+		var admitted atomic.Int64
+		defer func() { governor.Unreserve(len(req.URLs) - int(admitted.Load())) }()
+
+		jobScraper.RunJob(ctx, func(ctx context.Context) {
+			var wg sync.WaitGroup
+			if req.Crawl {
+				for i, id := range crawlIDs {
+					wg.Add(1)
+					go func(id int, url string) {
+						defer wg.Done()
+						admitted.Add(1)
+						jobScraper.Governed(ctx, true, func(ctx context.Context) {
+							if req.Sitemap {
+								seedSitemap(ctx, jobScraper, id, url, since, req.Incremental)
+							}
+							stats := jobScraper.RunCrawl(ctx, id)
+							log.Printf("crawl %d: %d fetched, %d failed, %d skipped, %d out of scope",
+								id, stats.Fetched.Load(), stats.Failed.Load(), stats.Skipped.Load(), stats.OutOfScope.Load())
+						})
+					}(id, req.URLs[i])
+				}
+			} else {
+				for _, url := range req.URLs {
+					wg.Add(1)
+					go func(url string) {
+						defer wg.Done()
+						admitted.Add(1)
+						jobScraper.Governed(ctx, true, func(ctx context.Context) {
+							jobScraper.Scrape(ctx, url, req.Depth)
+						})
+					}(url)
+				}
+			}
+			wg.Wait()
+		})
+		return nil
+	}
+}
+
+/* enqueue a site's sitemap urls before its crawl runs */
+func seedSitemap(ctx context.Context, jobScraper *scraper.Scraper, crawlID int, url string, since time.Time, incremental bool) {
+	if since.IsZero() && incremental {
+		last, err := jobScraper.LastCrawled(url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "crawl %d: %v\n", crawlID, err)
+		}
+		since = last
+	}
+	n, err := jobScraper.SeedFromSitemaps(ctx, crawlID, since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crawl %d sitemap: %v\n", crawlID, err)
+		return
+	}
+	log.Printf("crawl %d: %d sitemap urls enqueued", crawlID, n)
+}
+
+/* seed cookies from the request body, cookies.txt content or a file in COOKIES_DIR */
+func requestCookies(req BulkScrapeRequest) ([]scraper.SessionCookie, error) {
+	cookies := req.Cookies
+
+	if req.CookiesTxt != "" {
+		parsed, err := scraper.ParseNetscapeCookies(strings.NewReader(req.CookiesTxt))
+		if err != nil {
+			return nil, err
+		}
+		cookies = append(cookies, parsed...)
+	}
+
+	if req.CookiesFile != "" {
+		dir := os.Getenv("COOKIES_DIR")
+		if dir == "" {
+			dir = "./cookies"
+		}
+		// only plain file names, no paths outside the cookies dir
+		parsed, err := scraper.LoadNetscapeCookiesFile(filepath.Join(dir, filepath.Base(req.CookiesFile)))
+		if err != nil {
+			return nil, fmt.Errorf("cookies file: %w", err)
+		}
+		cookies = append(cookies, parsed...)
 	}
+	return cookies, nil
 }
@@ -3,11 +3,14 @@ package handler
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 
+	"webScraper/database"
 	"webScraper/scraper"
 )
 
@@ -39,6 +42,16 @@ func SetupRoutes(db *sql.DB, scraperInstance *scraper.Scraper, appCtx context.Co
 	mux.HandleFunc("/health", HealthCheckHandler(db))
 	mux.HandleFunc("/api/scrapes", ScrapesHandler(db))
 	mux.HandleFunc("/api/scrape/bulk", BulkScrapeHandler(db, scraperInstance, appCtx))
+	mux.HandleFunc("GET /api/jobs/{id}", JobHandler(db))
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", JobActionHandler(db, scraperInstance, appCtx, "cancel"))
+	mux.HandleFunc("POST /api/jobs/{id}/pause", JobActionHandler(db, scraperInstance, appCtx, "pause"))
+	mux.HandleFunc("POST /api/jobs/{id}/resume", JobActionHandler(db, scraperInstance, appCtx, "resume"))
+	mux.HandleFunc("GET /api/pool", PoolHandler(scraperInstance))
+	mux.HandleFunc("GET /api/governor", GovernorHandler(scraperInstance))
+	mux.HandleFunc("GET /api/queue", QueueHandler(scraperInstance))
+	mux.HandleFunc("GET /api/crawls/{id}", CrawlHandler(db))
+	mux.HandleFunc("POST /api/crawls/{id}/pause", CrawlStateHandler(scraperInstance, appCtx, scraper.CrawlPaused))
+	mux.HandleFunc("POST /api/crawls/{id}/resume", CrawlStateHandler(scraperInstance, appCtx, scraper.CrawlRunning))
 
 	return mux
 }
@@ -53,6 +66,35 @@ func HealthCheckHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+/* worker pool counters */
+func PoolHandler(scraperInstance *scraper.Scraper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scraperInstance.Pool.Metrics())
+	}
+}
+
+/* governor in-flight counts and backlog */
+func GovernorHandler(scraperInstance *scraper.Scraper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scraperInstance.Governor.Metrics())
+	}
+}
+
+/* task counts per queue state */
+func QueueHandler(scraperInstance *scraper.Scraper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts, err := scraperInstance.Queue.Counts()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(counts)
+	}
+}
+
 func ScrapesHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -60,58 +102,137 @@ func ScrapesHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		rows, err := db.Query(`
-			SELECT id, url, max_pages, concurrency, COALESCE(totalresults, 0) as totalresults, completed_at 
-			FROM raw_html 
-			WHERE completed_at IS NOT NULL 
-			ORDER BY completed_at DESC
-		`)
+		var qb database.QueryBuilder
+		parseResponseFilters(r, &qb)
+
+		rows, err := db.Query(qb.BuildScrapesQuery())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		defer rows.Close()
 
-		var scrapes []map[string]interface{}
-		for rows.Next() {
-			var id, maxPages, concurrency, totalResults int
-			var url string
-			var completedAt sql.NullTime
-
-			if err := rows.Scan(&id, &url, &maxPages, &concurrency, &totalResults, &completedAt); err != nil {
-				continue
-			}
-
-			scrape := map[string]interface{}{
-				"id":           id,
-				"url":          url,
-				"max_pages":    maxPages,
-				"concurrency":  concurrency,
-				"totalresults": totalResults,
-			}
-
-			if completedAt.Valid {
-				scrape["completed_at"] = completedAt.Time.Format("2006-01-02T15:04:05Z")
-			}
-
-			scrapes = append(scrapes, scrape)
+		scrapes, err := scanScrapeRows(rows)
+		if err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		if len(scrapes) == 0 {
-			fmt.Fprint(w, "[]")
-			return
+		json.NewEncoder(w).Encode(scrapes)
+	}
+}
+
+/* one raw_html row with response metadata */
+type scrapeRow struct {
+	ID             int             `json:"id"`
+	URL            string          `json:"url"`
+	MaxPages       int             `json:"max_pages"`
+	Concurrency    int             `json:"concurrency"`
+	TotalResults   int             `json:"totalresults"`
+	CompletedAt    string          `json:"completed_at,omitempty"`
+	StatusCode     int             `json:"status_code"`
+	Headers        json.RawMessage `json:"headers"`
+	ContentType    string          `json:"content_type"`
+	FinalURL       string          `json:"final_url"`
+	RedirectChain  json.RawMessage `json:"redirect_chain"`
+	ResponseTimeMS int64           `json:"response_time_ms"`
+	ByteSize       int64           `json:"byte_size"`
+	Encoding       string          `json:"encoding"`
+	ContentHash    string          `json:"content_hash"`
+	Changed        *bool           `json:"changed"` // against the previous fetch of the url, null if unknown
+}
+
+/* scan rows selected with database.RawHTMLColumns */
+func scanScrapeRows(rows *sql.Rows) ([]scrapeRow, error) {
+	scrapes := []scrapeRow{}
+	for rows.Next() {
+		s, err := scanScrapeRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		scrapes = append(scrapes, s)
+	}
+	return scrapes, rows.Err()
+}
+
+/* query batches of rows with their stored body until MaxRows match qb or the rows run out */
+func queryMatchingRows(db *sql.DB, qb *database.QueryBuilder) ([]scrapeRow, error) {
+	scrapes := []scrapeRow{}
+	for {
+		rows, err := db.Query(qb.BuildRawHTMLQuery())
+		if err != nil {
+			return nil, err
+		}
+		var scanned int
+		scrapes, scanned, err = scanMatchingRows(rows, qb, scrapes)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(scrapes) >= qb.MaxRows() || scanned < qb.ScanRows() {
+			return scrapes, nil
+		}
+		qb.ScanOffset += scanned
+	}
+}
+
+/* scan one batch of rows that also carry the stored body, appending those matching qb */
+func scanMatchingRows(rows *sql.Rows, qb *database.QueryBuilder, scrapes []scrapeRow) ([]scrapeRow, int, error) {
+	scanned := 0
+	for len(scrapes) < qb.MaxRows() && rows.Next() {
+		scanned++
+		var stored []byte
+		var compression string
+		s, err := scanScrapeRow(rows, &stored, &compression)
+		if err != nil {
+			return nil, scanned, err
 		}
+		body, err := database.DecompressBody(stored, compression)
+		if err != nil {
+			return nil, scanned, fmt.Errorf("row %d: %w", s.ID, err)
+		}
+		if qb.MatchBody(body) {
+			scrapes = append(scrapes, s)
+		}
+	}
+	return scrapes, scanned, rows.Err()
+}
+
+func scanScrapeRow(rows *sql.Rows, extra ...any) (scrapeRow, error) {
+	var s scrapeRow
+	var completedAt sql.NullTime
+	var headers, redirects []byte
+	var changed sql.NullBool
+	dest := []any{&s.ID, &s.URL, &s.MaxPages, &s.Concurrency, &s.TotalResults, &completedAt,
+		&s.StatusCode, &headers, &s.ContentType, &s.FinalURL, &redirects, &s.ResponseTimeMS, &s.ByteSize, &s.Encoding,
+		&s.ContentHash, &changed}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return s, err
+	}
+	if completedAt.Valid {
+		s.CompletedAt = completedAt.Time.Format("2006-01-02T15:04:05Z")
+	}
+	s.Headers = headers
+	s.RedirectChain = redirects
+	if changed.Valid {
+		s.Changed = &changed.Bool
+	}
+	return s, nil
+}
 
-		// simple JSON marshaling
-		fmt.Fprint(w, "[")
-		for i, scrape := range scrapes {
-			if i > 0 {
-				fmt.Fprint(w, ",")
-			}
-			fmt.Fprintf(w, `{"id":%d,"url":"%s","max_pages":%d,"concurrency":%d,"totalresults":%d,"completed_at":"%s"}`,
-				scrape["id"], scrape["url"], scrape["max_pages"], scrape["concurrency"], scrape["totalresults"], scrape["completed_at"])
+/* status, content_type, min_size, max_size, redirected and changed query params */
+func parseResponseFilters(r *http.Request, qb *database.QueryBuilder) {
+	q := r.URL.Query()
+	for _, v := range q["status"] {
+		if code, err := strconv.Atoi(v); err == nil {
+			qb.StatusCodes = append(qb.StatusCodes, code)
 		}
-		fmt.Fprint(w, "]")
 	}
+	qb.ContentType = q.Get("content_type")
+	qb.MinSize, _ = strconv.ParseInt(q.Get("min_size"), 10, 64)
+	qb.MaxSize, _ = strconv.ParseInt(q.Get("max_size"), 10, 64)
+	qb.Redirected = q.Get("redirected")
+	qb.Changed = q.Get("changed")
+	qb.ScanLimit, _ = strconv.Atoi(q.Get("scan"))
 }
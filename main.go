@@ -3,10 +3,14 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,6 +25,14 @@ import (
 var db *sql.DB
 
 func main() {
+	compressBlobs := flag.Bool("compress-blobs", false, "compress stored html bodies written before compression, then exit")
+	mode := flag.String("mode", "all", "server: serve the API and queue jobs, worker: run queued jobs, all: both")
+	flag.Parse()
+
+	if *mode != "server" && *mode != "worker" && *mode != "all" {
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+
 	var err error
 
 	/* db init and migrate stuff */
@@ -29,7 +41,39 @@ func main() {
 		log.Fatalf("DB init error: %v", err)
 	}
 
-	scraper := scraper.NewScraper(5, 10, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36", db)
+	userAgent := os.Getenv("SCRAPER_USER_AGENT")
+	if userAgent == "" {
+		userAgent = "webScraper/1.0 (+https://github.com/therealagt/webScraper)"
+	}
+	scraperInstance := scraper.NewScraper(5, 10, userAgent, db)
+	scraperInstance.SetTransport(transportConfigFromEnv())
+	rateLimitsFromEnv(scraperInstance.Limiter)
+
+	// one worker pool for the pages of all jobs
+	workers, queueSize := scraperInstance.MaxConcurrency, 0
+	envInt("SCRAPER_WORKERS", &workers)
+	envInt("SCRAPER_QUEUE_SIZE", &queueSize)
+	scraperInstance.Pool = scraper.NewPool(workers, queueSize)
+
+	// admission limits shared by all bulk requests, MAX_PER_HOST counts requests
+	gov := scraperInstance.Governor
+	envInt("SCRAPER_MAX_IN_FLIGHT", &gov.MaxInFlight)
+	envInt("SCRAPER_MAX_PER_HOST", &gov.MaxPerHost)
+	envInt("SCRAPER_MAX_BACKLOG", &gov.MaxBacklog)
+
+	// job queue shared by all server and worker processes
+	queue := scraperInstance.Queue
+	envDuration("SCRAPER_QUEUE_LEASE", &queue.Lease)
+	envInt("SCRAPER_QUEUE_ATTEMPTS", &queue.MaxAttempts)
+	envInt("SCRAPER_MAX_QUEUED", &queue.MaxPending)
+
+	if proxies := os.Getenv("SCRAPER_PROXIES"); proxies != "" {
+		pool, err := scraper.NewProxyPool(strings.Split(proxies, ","), os.Getenv("SCRAPER_PROXY_MODE"))
+		if err != nil {
+			log.Fatalf("Proxy config error: %v", err)
+		}
+		scraperInstance.Proxies = pool
+	}
 
 	if err := database.MigrateDatabase(db); err != nil {
 		log.Fatalf("Migration error: %v", err)
@@ -43,28 +87,93 @@ func main() {
 		log.Fatalf("RawHTML Migration error: %v", err)
 	}
 
+	if err := database.MigrateSkippedURLs(db); err != nil {
+		log.Fatalf("SkippedURLs Migration error: %v", err)
+	}
+
+	if err := database.MigrateFetchAttempts(db); err != nil {
+		log.Fatalf("FetchAttempts Migration error: %v", err)
+	}
+
+	if err := database.MigrateSessionCookies(db); err != nil {
+		log.Fatalf("SessionCookies Migration error: %v", err)
+	}
+
+	if err := database.MigrateCrawls(db); err != nil {
+		log.Fatalf("Crawls Migration error: %v", err)
+	}
+
+	if err := database.MigrateHTTPCache(db); err != nil {
+		log.Fatalf("HTTPCache Migration error: %v", err)
+	}
+
+	if err := database.MigrateHTMLBlobs(db); err != nil {
+		log.Fatalf("HTMLBlobs Migration error: %v", err)
+	}
+
+	if err := database.MigrateScrapeJobs(db); err != nil {
+		log.Fatalf("ScrapeJobs Migration error: %v", err)
+	}
+
+	if err := database.MigrateTaskQueue(db); err != nil {
+		log.Fatalf("TaskQueue Migration error: %v", err)
+	}
+
+	if *compressBlobs {
+		n, err := database.CompressBlobs(db, 500)
+		if err != nil {
+			log.Fatalf("Compress blobs error after %d: %v", n, err)
+		}
+		log.Printf("Compressed %d html blobs", n)
+		return
+	}
+
 	// global context for shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
-	/* route handling */
-	mux := handler.SetupRoutes(db, scraper, ctx)
+	// run queued jobs, and crawls the last shutdown interrupted
+	workerDone := make(chan struct{})
+	if *mode == "server" {
+		close(workerDone)
+	} else {
+		if err := scraperInstance.ResumeCrawls(ctx); err != nil {
+			log.Printf("Resume crawls error: %v", err)
+		}
 
-	/* server config */
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		slots := 2
+		envInt("SCRAPER_QUEUE_SLOTS", &slots)
+		hostname, _ := os.Hostname()
+		worker := scraperInstance.NewWorker(fmt.Sprintf("%s-%d", hostname, os.Getpid()), slots)
+		worker.Handle(scraper.TaskBulkJob, handler.BulkJobTask(scraperInstance))
+		go func() {
+			worker.Run(ctx)
+			close(workerDone)
+		}()
+		log.Printf("Worker %s runs %d queued jobs at a time", worker.Name, worker.Slots)
 	}
 
-	go func() {
-		log.Printf("Server runs on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe: %v", err)
+	var srv *http.Server
+	if *mode != "worker" {
+		/* route handling */
+		mux := handler.SetupRoutes(db, scraperInstance, ctx)
+
+		/* server config */
+		srv = &http.Server{
+			Addr:    ":8080",
+			Handler: mux,
 		}
-	}()
 
-	log.Println("Server started. Use the web interface to upload URLs and start scraping.")
-	log.Println("Open http://localhost:8080 in your browser.")
+		go func() {
+			log.Printf("Server runs on %s", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("ListenAndServe: %v", err)
+			}
+		}()
+
+		log.Println("Server started. Use the web interface to upload URLs and start scraping.")
+		log.Println("Open http://localhost:8080 in your browser.")
+	}
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -72,15 +181,81 @@ func main() {
 	log.Println("Shutdown...")
 
 	// cancel context to stop all scrapers
-	cancel()
+	cancel(scraper.ErrShutdown)
 
-	// wait briefly to allow scrapers to stop gracefully
+	// wait briefly to allow scrapers to stop gracefully, queued jobs go back to the queue
 	time.Sleep(2 * time.Second)
+	<-workerDone
+	scraperInstance.Pool.Close()
+
+	if srv != nil {
+		ctxServer, cancelServer := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelServer()
+		if err := srv.Shutdown(ctxServer); err != nil {
+			log.Fatalf("Server Shutdown: %v", err)
+		}
+		log.Println("Server stopped.")
+	}
+}
+
+/* transport tuning, SCRAPER_* env vars override the defaults */
+func transportConfigFromEnv() scraper.TransportConfig {
+	cfg := scraper.DefaultTransportConfig()
+	envInt("SCRAPER_MAX_IDLE_CONNS", &cfg.MaxIdleConns)
+	envInt("SCRAPER_MAX_IDLE_CONNS_PER_HOST", &cfg.MaxIdleConnsPerHost)
+	envInt("SCRAPER_MAX_CONNS_PER_HOST", &cfg.MaxConnsPerHost)
+	envDuration("SCRAPER_IDLE_CONN_TIMEOUT", &cfg.IdleConnTimeout)
+	envDuration("SCRAPER_DNS_CACHE_TTL", &cfg.DNSCacheTTL)
+	if v := os.Getenv("SCRAPER_HTTP2"); v != "" {
+		cfg.ForceHTTP2 = v == "true" || v == "1"
+	}
+	if v := os.Getenv("SCRAPER_KEEPALIVE"); v != "" {
+		cfg.DisableKeepAlives = v == "false" || v == "0"
+	}
+	return cfg
+}
 
-	ctxServer, cancelServer := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelServer()
-	if err := srv.Shutdown(ctxServer); err != nil {
-		log.Fatalf("Server Shutdown: %v", err)
+/* SCRAPER_RATE_LIMIT=1s:1 for all hosts, SCRAPER_DOMAIN_LIMITS=example.com=2s,shop.example=500ms:3 per domain */
+func rateLimitsFromEnv(l *scraper.HostLimiter) {
+	if v := os.Getenv("SCRAPER_RATE_LIMIT"); v != "" {
+		rl, err := scraper.ParseRateLimit(v)
+		if err != nil {
+			log.Fatalf("SCRAPER_RATE_LIMIT: %v", err)
+		}
+		l.SetDefault(rl)
+	}
+	for _, entry := range strings.Split(os.Getenv("SCRAPER_DOMAIN_LIMITS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		domain, limit, ok := strings.Cut(entry, "=")
+		if !ok || domain == "" {
+			log.Fatalf("SCRAPER_DOMAIN_LIMITS: %q is not domain=limit", entry)
+		}
+		rl, err := scraper.ParseRateLimit(limit)
+		if err != nil {
+			log.Fatalf("SCRAPER_DOMAIN_LIMITS: %s: %v", domain, err)
+		}
+		l.SetDomain(domain, rl)
+	}
+}
+
+func envInt(name string, dst *int) {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		*dst = n
+	}
+}
+
+func envDuration(name string, dst *time.Duration) {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		*dst = d
 	}
-	log.Println("Server stopped.")
 }
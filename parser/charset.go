@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+/* charset of a page from BOM, Content-Type and <meta charset> */
+func DetectCharset(body []byte, contentType string) string {
+	_, name, _ := charset.DetermineEncoding(body, contentType)
+	return name
+}
+
+/* transcode a page to UTF-8, BOM stripped */
+func ToUTF8(body []byte, charsetName string) ([]byte, error) {
+	name := strings.ToLower(strings.TrimSpace(charsetName))
+	if name == "" || name == "utf-8" || name == "utf8" {
+		return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), nil
+	}
+
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return body, fmt.Errorf("unknown charset %q", charsetName)
+	}
+	return enc.NewDecoder().Bytes(body)
+}
@@ -1,18 +1,27 @@
 package scraper
 
+import "time"
+
 /* task definition */
 type Task struct {
-	URL string
-	Depth int
+	URL      string
+	Depth    int
 	Callback func(Result)
+	MaxPages int           // stored with the page, pages of a listing
+	Timeout  time.Duration // whole task with retries, 0 means none
+	Scraper  *Scraper      // job settings the page is fetched with
 }
 
 type Result struct {
-	Title string
-	Description string 
-	Links []string
-	Metadata map[string]string 
-	StatusCode int
-	RawHTML []byte
-	Error error
-}
\ No newline at end of file
+	Title       string
+	Description string
+	Links       []string
+	Metadata    map[string]string
+	StatusCode  int
+	RawHTML     []byte
+	Error       error
+	URL         string
+	FinalURL    string
+	Encoding    string
+	Canonical   string // rel=canonical
+}
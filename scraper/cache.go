@@ -0,0 +1,210 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"webScraper/database"
+)
+
+/* job cache modes */
+const (
+	CacheOff        = "off"        // always a plain GET
+	CacheRevalidate = "revalidate" // conditional GET with the stored ETag / Last-Modified
+	CachePrefer     = "prefer"     // no request at all while Cache-Control says fresh, else revalidate
+)
+
+/* cache outcomes of one job */
+type CacheStats struct {
+	Hits        atomic.Int64 // served locally while fresh
+	Revalidated atomic.Int64 // 304 Not Modified
+	Misses      atomic.Int64 // full download
+}
+
+func (c *CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d revalidated, %d misses", c.Hits.Load(), c.Revalidated.Load(), c.Misses.Load())
+}
+
+/* validators and freshness stored per url and variant */
+type cacheEntry struct {
+	etag         string
+	lastModified string
+	finalURL     string
+	contentHash  string
+	expiresAt    sql.NullTime
+}
+
+func (s *Scraper) cacheMode() string {
+	if _, ok := s.cacheVariant(); !ok {
+		return CacheOff
+	}
+	switch s.Options.Cache {
+	case CacheOff, CachePrefer:
+		return s.Options.Cache
+	default:
+		return CacheRevalidate
+	}
+}
+
+/* hash of the headers the job sends, which covers any Vary; jobs sending cookies don't use the cache */
+func (s *Scraper) cacheVariant() (string, bool) {
+	if s.Options.Session != "" || len(s.Options.Cookies) > 0 || s.Options.Login != nil {
+		return "", false
+	}
+	headers := s.requestHeaderMap()
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, k := range names {
+		fmt.Fprintf(h, "%s: %s\n", k, headers[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+/* cache counters of the job */
+func (s *Scraper) CacheStats() *CacheStats {
+	return s.cacheStats
+}
+
+func (s *Scraper) loadCacheEntry(url string) (*cacheEntry, bool) {
+	variant, _ := s.cacheVariant()
+	var e cacheEntry
+	err := s.DB.QueryRow(
+		`SELECT COALESCE(etag, ''), COALESCE(last_modified, ''), COALESCE(final_url, ''), COALESCE(content_hash, ''), expires_at
+        FROM http_cache WHERE url = $1 AND variant = $2`,
+		url, variant,
+	).Scan(&e.etag, &e.lastModified, &e.finalURL, &e.contentHash, &e.expiresAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Fprintf(os.Stderr, "cache lookup %s: %v\n", url, err)
+		}
+		return nil, false
+	}
+	return &e, true
+}
+
+/* If-None-Match / If-Modified-Since for a stored entry */
+func (e *cacheEntry) validators() http.Header {
+	h := make(http.Header)
+	if e.etag != "" {
+		h.Set("If-None-Match", e.etag)
+	}
+	if e.lastModified != "" {
+		h.Set("If-Modified-Since", e.lastModified)
+	}
+	return h
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.expiresAt.Valid && now.Before(e.expiresAt.Time)
+}
+
+/* store validators of a 200 or 304 with the hash of its stored body, no-store and Vary: * drop the entry */
+func (s *Scraper) saveCacheEntry(url string, res *fetchResult) {
+	variant, _ := s.cacheVariant()
+	cc := parseCacheControl(res.Header.Get("Cache-Control"))
+	_, noStore := cc["no-store"]
+	if noStore || strings.TrimSpace(res.Header.Get("Vary")) == "*" {
+		if _, err := s.DB.Exec(`DELETE FROM http_cache WHERE url = $1 AND variant = $2`, url, variant); err != nil {
+			fmt.Fprintf(os.Stderr, "cache delete %s: %v\n", url, err)
+		}
+		return
+	}
+
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	expiresAt := freshUntil(res.Header, cc, time.Now())
+	_, err := s.DB.Exec(
+		`INSERT INTO http_cache (url, variant, etag, last_modified, final_url, content_hash, expires_at, updated_at)
+        VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, NOW())
+        ON CONFLICT (url, variant) DO UPDATE SET
+            etag = COALESCE(EXCLUDED.etag, http_cache.etag),
+            last_modified = COALESCE(EXCLUDED.last_modified, http_cache.last_modified),
+            final_url = EXCLUDED.final_url,
+            content_hash = EXCLUDED.content_hash,
+            expires_at = EXCLUDED.expires_at,
+            updated_at = NOW()`,
+		url, variant, etag, lastModified, res.FinalURL, bodyHash(res.Body), expiresAt,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache save %s: %v\n", url, err)
+	}
+}
+
+/* body the cache entry was saved with, used for 304s and cache hits */
+func (s *Scraper) loadStoredBody(e *cacheEntry, res *fetchResult) bool {
+	if e.contentHash == "" {
+		return false
+	}
+	var contentType, compression string
+	var stored []byte
+	err := s.DB.QueryRow(
+		`SELECT `+database.RawHTMLBody+`, `+database.RawHTMLCompression+`, COALESCE(encoding, ''), COALESCE(content_type, '')
+        FROM `+database.RawHTMLSource+`
+        WHERE raw_html.content_hash = $1 AND status_code = 200 ORDER BY raw_html.id DESC LIMIT 1`,
+		e.contentHash,
+	).Scan(&stored, &compression, &res.Encoding, &contentType)
+	if err != nil {
+		return false
+	}
+	if res.Body, err = database.DecompressBody(stored, compression); err != nil {
+		fmt.Fprintf(os.Stderr, "stored body %s: %v\n", e.contentHash, err)
+		return false
+	}
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	if res.Header.Get("Content-Type") == "" {
+		res.Header.Set("Content-Type", contentType)
+	}
+	return true
+}
+
+/* sha256 of an uncompressed body, html_blobs are keyed by it */
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+/* Cache-Control directives, lowercased names */
+func parseCacheControl(v string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+/* end of freshness from max-age (minus Age) or Expires, invalid means revalidate every time */
+func freshUntil(h http.Header, cc map[string]string, now time.Time) sql.NullTime {
+	if _, ok := cc["no-cache"]; ok {
+		return sql.NullTime{}
+	}
+	if maxAge, ok := cc["max-age"]; ok {
+		secs, err := strconv.Atoi(maxAge)
+		if err != nil || secs <= 0 {
+			return sql.NullTime{}
+		}
+		age, _ := strconv.Atoi(h.Get("Age"))
+		return sql.NullTime{Time: now.Add(time.Duration(secs-age) * time.Second), Valid: secs > age}
+	}
+	if exp, err := http.ParseTime(h.Get("Expires")); err == nil && exp.After(now) {
+		return sql.NullTime{Time: exp, Valid: true}
+	}
+	return sql.NullTime{}
+}
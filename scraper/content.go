@@ -0,0 +1,112 @@
+package scraper
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const sniffLen = 512
+
+/* content types stored by default */
+func DefaultContentTypes() []string {
+	return []string{"text/html", "application/xhtml+xml", "application/json", "application/xml", "text/xml"}
+}
+
+/* resource deliberately not fetched or stored */
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
+func skipped(format string, args ...any) error {
+	return &SkipError{Reason: fmt.Sprintf(format, args...)}
+}
+
+/* reason if err is a skip */
+func skipReason(err error) (string, bool) {
+	var se *SkipError
+	if errors.As(err, &se) {
+		return se.Reason, true
+	}
+	return "", false
+}
+
+/* media type without parameters, lowercased */
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+func (s *Scraper) contentTypeAllowed(contentType string) bool {
+	if len(s.AllowedContentTypes) == 0 {
+		return true
+	}
+	return slices.Contains(s.AllowedContentTypes, mediaType(contentType))
+}
+
+/* HEAD before GET, skips disallowed types without downloading */
+func (s *Scraper) headCheck(ctx context.Context, u *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	s.setRequestHeaders(req)
+
+	resp, _, err := s.do(req)
+	if err != nil {
+		// servers that can't HEAD still get the GET and the sniff
+		return nil
+	}
+	resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode < 300 && ct != "" && !s.contentTypeAllowed(ct) {
+		return skipped("content type %s not allowed", mediaType(ct))
+	}
+	return nil
+}
+
+/* check content type (header or sniffed) and read at most MaxBodyBytes */
+func (s *Scraper) readBody(resp *http.Response) ([]byte, bool, error) {
+	br := bufio.NewReaderSize(resp.Body, sniffLen)
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		peek, err := br.Peek(sniffLen)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, false, err
+		}
+		contentType = http.DetectContentType(peek)
+		resp.Header.Set("Content-Type", contentType)
+	}
+	// error pages keep their type, a 503 stays retryable
+	if resp.StatusCode < 300 && !s.contentTypeAllowed(contentType) {
+		return nil, false, skipped("content type %s not allowed", mediaType(contentType))
+	}
+
+	if s.MaxBodyBytes <= 0 {
+		body, err := io.ReadAll(br)
+		return body, false, err
+	}
+	body, err := io.ReadAll(io.LimitReader(br, s.MaxBodyBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > s.MaxBodyBytes {
+		return body[:s.MaxBodyBytes], true, nil
+	}
+	return body, false, nil
+}
@@ -0,0 +1,60 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/* scraper without delays or robots.txt, retrying quickly */
+func contentScraper() *Scraper {
+	s := NewScraper(1, 5, "content-test", stubDB())
+	s.RespectRobots = false
+	s.Limiter = NewHostLimiter(RateLimit{})
+	s.Retry.BaseDelay = time.Millisecond
+	s.Retry.Jitter = 0
+	return s
+}
+
+func TestFetchRetriesErrorPagesOfAnyType(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "try again later")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html></html>")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	res, err := contentScraper().fetch(context.Background(), u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(res.Attempts) != 2 {
+		t.Fatalf("status %d after %d attempts, want 200 after 2", res.StatusCode, len(res.Attempts))
+	}
+}
+
+func TestFetchSkipsDisallowedContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		io.WriteString(w, "PK")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	_, err := contentScraper().fetch(context.Background(), u, nil)
+	if reason, ok := skipReason(err); !ok || reason != "content type application/zip not allowed" {
+		t.Fatalf("err %v, want a content type skip", err)
+	}
+}
@@ -0,0 +1,296 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+/* cookie as persisted or seeded, net/http drops host-only info */
+type SessionCookie struct {
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	HostOnly bool      `json:"host_only"`
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only"`
+	Expires  time.Time `json:"expires,omitempty"`
+}
+
+/* cookiejar that remembers what it stored so it can be persisted */
+type SessionJar struct {
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	cookies map[string]SessionCookie
+}
+
+func NewSessionJar() *SessionJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &SessionJar{jar: jar, cookies: make(map[string]SessionCookie)}
+}
+
+func (j *SessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		sc := SessionCookie{
+			Domain:   strings.TrimPrefix(strings.ToLower(c.Domain), "."),
+			Path:     c.Path,
+			Name:     c.Name,
+			Value:    c.Value,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			Expires:  c.Expires,
+		}
+		if sc.Domain == "" {
+			sc.Domain = strings.ToLower(u.Hostname())
+			sc.HostOnly = true
+		} else if !cookieDomainAllowed(u.Hostname(), sc.Domain) {
+			// rejected by the jar as well, e.g. another site's domain
+			continue
+		}
+		if sc.Path == "" || sc.Path[0] != '/' {
+			sc.Path = defaultCookiePath(u.Path)
+		}
+		if c.MaxAge > 0 {
+			sc.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+
+		key := sc.Domain + ";" + sc.Path + ";" + sc.Name
+		if c.MaxAge < 0 || (!sc.Expires.IsZero() && sc.Expires.Before(time.Now())) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = sc
+	}
+}
+
+func (j *SessionJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+/* put persisted or user supplied cookies into the jar */
+func (j *SessionJar) Seed(cookies []SessionCookie) {
+	for _, sc := range cookies {
+		scheme := "http"
+		if sc.Secure {
+			scheme = "https"
+		}
+		p := sc.Path
+		if p == "" {
+			p = "/"
+		}
+		c := &http.Cookie{
+			Name:     sc.Name,
+			Value:    sc.Value,
+			Path:     p,
+			Secure:   sc.Secure,
+			HttpOnly: sc.HttpOnly,
+			Expires:  sc.Expires,
+		}
+		if !sc.HostOnly {
+			c.Domain = sc.Domain
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: sc.Domain, Path: p}, []*http.Cookie{c})
+	}
+}
+
+/* all unexpired cookies */
+func (j *SessionJar) All() []SessionCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var all []SessionCookie
+	now := time.Now()
+	for _, sc := range j.cookies {
+		if sc.Expires.IsZero() || sc.Expires.After(now) {
+			all = append(all, sc)
+		}
+	}
+	return all
+}
+
+/* RFC 6265 domain-match of a Domain attribute, never a public suffix or another site */
+func cookieDomainAllowed(host, domain string) bool {
+	host = strings.ToLower(host)
+	if host == domain {
+		return true
+	}
+	if net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+domain) {
+		return false
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix != domain
+}
+
+/* RFC 6265 default-path */
+func defaultCookiePath(p string) string {
+	if p == "" || p[0] != '/' {
+		return "/"
+	}
+	dir := path.Dir(p)
+	if strings.HasSuffix(p, "/") {
+		dir = strings.TrimSuffix(p, "/")
+	}
+	if dir == "" || dir == "." {
+		return "/"
+	}
+	return dir
+}
+
+/* parse a Netscape/curl cookies.txt */
+func ParseNetscapeCookies(r io.Reader) ([]SessionCookie, error) {
+	var cookies []SessionCookie
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 tab separated fields", lineNo)
+		}
+		domain := strings.ToLower(fields[0])
+		c := SessionCookie{
+			Domain:   strings.TrimPrefix(domain, "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    strings.Join(fields[6:], "\t"),
+			HttpOnly: httpOnly,
+		}
+		if exp, err := strconv.ParseInt(fields[4], 10, 64); err == nil && exp > 0 {
+			c.Expires = time.Unix(exp, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, sc.Err()
+}
+
+/* start the job's browsing session: persisted cookies, then seeded ones */
+func (s *Scraper) StartSession() error {
+	if s.jar == nil {
+		return nil
+	}
+	if s.Options.Session != "" {
+		persisted, err := s.loadSessionFromDB(s.Options.Session)
+		if err != nil {
+			return err
+		}
+		s.jar.Seed(persisted)
+	}
+	s.jar.Seed(s.Options.Cookies)
+	return nil
+}
+
+/* move seeded cookies into the job's session, a job without one gets its own; keeps cookie values out of stored options */
+func (s *Scraper) PersistCookies(jobID int) error {
+	if len(s.Options.Cookies) == 0 {
+		return nil
+	}
+	if s.Options.Session == "" {
+		s.Options.Session = fmt.Sprintf("job:%d", jobID)
+	}
+	if err := s.StartSession(); err != nil {
+		return err
+	}
+	if err := s.SaveSession(); err != nil {
+		return err
+	}
+	s.Options.Cookies = nil
+	return nil
+}
+
+/* persist the job's cookies for the next run */
+func (s *Scraper) SaveSession() error {
+	if s.jar == nil || s.Options.Session == "" {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM session_cookies WHERE session = $1`, s.Options.Session); err != nil {
+		return err
+	}
+	for _, c := range s.jar.All() {
+		var expires any
+		if !c.Expires.IsZero() {
+			expires = c.Expires
+		}
+		_, err := tx.Exec(
+			`INSERT INTO session_cookies
+            (session, domain, path, name, value, host_only, secure, http_only, expires)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			s.Options.Session, c.Domain, c.Path, c.Name, c.Value, c.HostOnly, c.Secure, c.HttpOnly, expires,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+/* session cookies db read */
+func (s *Scraper) loadSessionFromDB(session string) ([]SessionCookie, error) {
+	rows, err := s.DB.Query(
+		`SELECT domain, path, name, value, host_only, secure, http_only, expires
+        FROM session_cookies
+        WHERE session = $1 AND (expires IS NULL OR expires > NOW())`,
+		session,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cookies []SessionCookie
+	for rows.Next() {
+		var c SessionCookie
+		var expires *time.Time
+		if err := rows.Scan(&c.Domain, &c.Path, &c.Name, &c.Value, &c.HostOnly, &c.Secure, &c.HttpOnly, &expires); err != nil {
+			return nil, err
+		}
+		if expires != nil {
+			c.Expires = *expires
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, rows.Err()
+}
+
+/* read cookies.txt from disk */
+func LoadNetscapeCookiesFile(filename string) ([]SessionCookie, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseNetscapeCookies(file)
+}
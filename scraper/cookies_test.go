@@ -0,0 +1,85 @@
+package scraper
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionJarKeepsOnlyAcceptedCookies(t *testing.T) {
+	tests := []struct {
+		from   string
+		domain string
+		saved  bool
+	}{
+		{"https://www.example.com/", "", true},
+		{"https://www.example.com/", "example.com", true},
+		{"https://www.example.com/", ".example.com", true},
+		{"https://example.com/", "example.com", true},
+		{"https://evil.example/", "bank.example", false},
+		{"https://www.example.com/", "other.com", false},
+		{"https://shop.co.uk/", "co.uk", false},
+		{"https://example.com/", "www.example.com", false},
+		{"http://127.0.0.1/", "0.0.1", false},
+	}
+	for _, tt := range tests {
+		j := NewSessionJar()
+		u, _ := url.Parse(tt.from)
+		j.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "1", Domain: tt.domain}})
+		if saved := len(j.All()) == 1; saved != tt.saved {
+			t.Errorf("Domain=%q from %s: saved %v, want %v", tt.domain, tt.from, saved, tt.saved)
+		}
+	}
+}
+
+func TestSessionJarSeedRoundTrip(t *testing.T) {
+	j := NewSessionJar()
+	j.Seed([]SessionCookie{
+		{Domain: "example.com", Path: "/", Name: "a", Value: "1"},
+		{Domain: "shop.example.com", Path: "/cart", Name: "b", Value: "2", HostOnly: true},
+	})
+
+	u, _ := url.Parse("http://shop.example.com/cart/items")
+	var names []string
+	for _, c := range j.Cookies(u) {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	if got := strings.Join(names, ";"); got != "b=2;a=1" {
+		t.Fatalf("cookies sent %q, want b=2;a=1", got)
+	}
+	if n := len(j.All()); n != 2 {
+		t.Fatalf("%d cookies saved, want 2", n)
+	}
+}
+
+func TestParseNetscapeCookies(t *testing.T) {
+	in := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc",
+		"#HttpOnly_shop.example.com\tFALSE\t/cart\tTRUE\t1700000000\ttoken\tx\ty",
+	}, "\n")
+
+	got, err := ParseNetscapeCookies(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SessionCookie{
+		{Domain: "example.com", Path: "/", Name: "session", Value: "abc"},
+		{Domain: "shop.example.com", HostOnly: true, Path: "/cart", Secure: true, HttpOnly: true,
+			Name: "token", Value: "x\ty", Expires: time.Unix(1700000000, 0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseNetscapeCookiesShortLine(t *testing.T) {
+	_, err := ParseNetscapeCookies(strings.NewReader("# header\nexample.com\tTRUE\t/\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("err %v, want an error for line 2", err)
+	}
+}
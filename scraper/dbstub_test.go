@@ -0,0 +1,79 @@
+package scraper
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+)
+
+/* database/sql driver that returns no rows and records every statement, for tests without postgres */
+type stubDriver struct{}
+
+type stubConn struct{}
+
+type stubStmt struct{ query string }
+
+/* a statement run against the stub */
+type stubExec struct {
+	Query string
+	Args  []driver.Value
+}
+
+var (
+	stubMu    sync.Mutex
+	stubExecs []stubExec
+)
+
+func init() {
+	sql.Register("stub", stubDriver{})
+}
+
+/* fresh stub database, clears the recorded statements */
+func stubDB() *sql.DB {
+	stubMu.Lock()
+	stubExecs = nil
+	stubMu.Unlock()
+	db, _ := sql.Open("stub", "")
+	return db
+}
+
+/* recorded statements whose query contains part */
+func stubExecsLike(part string) []stubExec {
+	stubMu.Lock()
+	defer stubMu.Unlock()
+	var out []stubExec
+	for _, e := range stubExecs {
+		if strings.Contains(e.Query, part) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return stubStmt{query}, nil }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return stubConn{}, nil }
+func (stubConn) Commit() error                             { return nil }
+func (stubConn) Rollback() error                           { return nil }
+
+func (stubStmt) Close() error  { return nil }
+func (stubStmt) NumInput() int { return -1 }
+
+func (s stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	stubMu.Lock()
+	defer stubMu.Unlock()
+	stubExecs = append(stubExecs, stubExec{Query: strings.Join(strings.Fields(s.query), " "), Args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (stubStmt) Query([]driver.Value) (driver.Rows, error) { return stubRows{}, nil }
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string         { return nil }
+func (stubRows) Close() error              { return nil }
+func (stubRows) Next([]driver.Value) error { return io.EOF }
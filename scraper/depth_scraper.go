@@ -1,47 +1,310 @@
 package scraper
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
 
-	"github.com/PuerkitoBio/goquery"
+	"webScraper/database"
 )
 
-func (s *Scraper) ScrapeWithDepth(ctx context.Context, startURL string, maxDepth int) {
-	visited := make(map[string]bool)
-	s.scrapeRecursive(ctx, startURL, 1, maxDepth, visited)
+/* counters of a depth crawl run */
+type CrawlStats struct {
+	Fetched    atomic.Int64
+	Failed     atomic.Int64
+	Skipped    atomic.Int64
+	Discovered atomic.Int64
+	OutOfScope atomic.Int64
 }
 
-func (s *Scraper) scrapeRecursive(ctx context.Context, urlStr string, depth, maxDepth int, visited map[string]bool) {
-	if depth > maxDepth || visited[urlStr] {
-		return 
+/* crawls running in this process, a crawl is never run twice at once */
+var activeCrawls sync.Map
+
+/* whether a crawl's workers are running in this process */
+func CrawlActive(crawlID int) bool {
+	_, ok := activeCrawls.Load(crawlID)
+	return ok
+}
+
+/* concurrent BFS crawl, MaxConcurrency workers share one persisted frontier */
+func (s *Scraper) ScrapeWithDepth(ctx context.Context, startURL string, maxDepth int) *CrawlStats {
+	id, err := s.NewCrawl(startURL, maxDepth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crawl: %v\n", err)
+		return &CrawlStats{}
 	}
-	visited[urlStr] = true
+	return s.RunCrawl(ctx, id)
+}
 
-	s.fetchPage(ctx, urlStr, 1, 0, sql.NullTime{Valid: false})
+/* store a crawl with its job options and seed url */
+func (s *Scraper) NewCrawl(startURL string, maxDepth int) (int, error) {
+	start, err := s.Canonicalizer.CanonicalizeString(startURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid start url %q: %w", startURL, err)
+	}
+	options, err := json.Marshal(s.Options)
+	if err != nil {
+		return 0, err
+	}
 
-	links := extractLinksFromHTML(s.DB, urlStr)
-	for _, link := range links {
-		s.scrapeRecursive(ctx, link, depth+1, maxDepth, visited)
+	var id int
+	err = s.DB.QueryRow(
+		`INSERT INTO crawls (start_url, max_depth, options, state, job_id) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		start, maxDepth, options, CrawlRunning, s.job.jobID(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
 	}
+	_, err = s.DB.Exec(
+		`INSERT INTO crawl_frontier (crawl_id, url, depth, state) VALUES ($1, $2, 1, $3)`,
+		id, start, FrontierQueued,
+	)
+	return id, err
 }
 
-func extractLinksFromHTML(db *sql.DB, urlStr string) []string {
-    var html []byte
-    err := db.QueryRow("SELECT html FROM raw_html WHERE url = $1", urlStr).Scan(&html)
-    if err != nil {
-        return nil
-    }
-    doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
-    if err != nil {
-        return nil
-    }
-    var links []string
-    doc.Find("a").Each(func(i int, s *goquery.Selection) {
-        if href, exists := s.Attr("href"); exists {
-            links = append(links, href)
-        }
-    })
-    return links
+/* work off a crawl's frontier until it is exhausted, paused or ctx ends */
+func (s *Scraper) RunCrawl(ctx context.Context, crawlID int) *CrawlStats {
+	stats := &CrawlStats{}
+	if _, running := activeCrawls.LoadOrStore(crawlID, true); running {
+		return stats
+	}
+	defer activeCrawls.Delete(crawlID)
+
+	f, _, maxDepth, err := s.openFrontier(crawlID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crawl %d: %v\n", crawlID, err)
+		return stats
+	}
+	if err := f.recover(); err != nil {
+		fmt.Fprintf(os.Stderr, "crawl %d: %v\n", crawlID, err)
+		return stats
+	}
+
+	stop := context.AfterFunc(ctx, f.wake)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(s.MaxConcurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, ok, err := f.next(ctx)
+				if err != nil && ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "crawl %d: %v\n", crawlID, err)
+				}
+				if !ok {
+					return
+				}
+				s.crawlPage(ctx, f, item, maxDepth, stats)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.finishCrawl(ctx, crawlID)
+	return stats
+}
+
+/* frontier of a stored crawl with the job's budget and scope */
+func (s *Scraper) openFrontier(crawlID int) (*frontier, string, int, error) {
+	var startURL string
+	var maxDepth int
+	err := s.DB.QueryRow(`SELECT start_url, max_depth FROM crawls WHERE id = $1`, crawlID).Scan(&startURL, &maxDepth)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	f := newFrontier(s.DB, crawlID, s.Options.MaxTotalPages)
+	if err := f.countEnqueued(); err != nil {
+		return nil, "", 0, err
+	}
+	scope := DefaultScope()
+	if s.Options.Scope != nil {
+		scope = *s.Options.Scope
+	}
+	startU, _ := url.Parse(startURL)
+	if f.scope, err = scope.compile(startU); err != nil {
+		return nil, "", 0, err
+	}
+	if scope.MaxPerHost > 0 {
+		if err := f.countPerHost(startURL); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	return f, startURL, maxDepth, nil
+}
+
+/* done once nothing is left, a deadline pauses; on shutdown it stays running and resumes on start */
+func (s *Scraper) finishCrawl(ctx context.Context, crawlID int) {
+	var err error
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		_, err = s.DB.Exec(
+			`UPDATE crawls SET state = $2, updated_at = NOW() WHERE id = $1 AND state = $3`,
+			crawlID, CrawlPaused, CrawlRunning,
+		)
+	case ctx.Err() == nil:
+		_, err = s.DB.Exec(
+			`UPDATE crawls SET state = $2, updated_at = NOW()
+            WHERE id = $1 AND state = $3 AND NOT EXISTS (
+                SELECT 1 FROM crawl_frontier WHERE crawl_id = $1 AND state IN ($4, $5)
+            )`,
+			crawlID, CrawlDone, CrawlRunning, FrontierQueued, FrontierInFlight,
+		)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crawl %d: %v\n", crawlID, err)
+	}
+}
+
+/* fetch one page, store its outcome and enqueue its links one level deeper */
+func (s *Scraper) crawlPage(ctx context.Context, f *frontier, item crawlItem, maxDepth int, stats *CrawlStats) {
+	state, errMsg := FrontierDone, ""
+	defer func() {
+		if err := f.done(item, state, errMsg); err != nil {
+			fmt.Fprintf(os.Stderr, "crawl %d: %v\n", f.crawlID, err)
+		}
+	}()
+
+	res := s.doTask(ctx, Task{URL: item.url, Depth: item.depth, MaxPages: 1})
+	err := res.Error
+	if err != nil && ctx.Err() != nil {
+		// interrupted, not failed or skipped: fetched again on resume
+		state = FrontierQueued
+		return
+	}
+	if reason, ok := skipReason(err); ok {
+		stats.Skipped.Add(1)
+		state, errMsg = FrontierSkipped, reason
+		return
+	}
+	if err != nil {
+		stats.Failed.Add(1)
+		state, errMsg = FrontierFailed, err.Error()
+		return
+	}
+	stats.Fetched.Add(1)
+
+	pageURL, err := url.Parse(res.FinalURL)
+	if err != nil {
+		return
+	}
+	links, canonical := res.Links, res.Canonical
+	// same document under another url, don't fetch it again
+	if final, err := s.Canonicalizer.Canonicalize(pageURL); err == nil && final != item.url {
+		if err := f.markVisited(final, item.depth); err != nil {
+			fmt.Fprintf(os.Stderr, "crawl %d: %v\n", f.crawlID, err)
+		}
+	}
+	if canonical != "" && canonical != item.url {
+		if err := f.markVisited(canonical, item.depth); err != nil {
+			fmt.Fprintf(os.Stderr, "crawl %d: %v\n", f.crawlID, err)
+		}
+	}
+
+	if item.depth >= maxDepth || len(links) == 0 {
+		return
+	}
+	results, err := f.pushAll(links, item.depth+1)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "crawl %d: %v\n", f.crawlID, err)
+		return
+	}
+	for _, r := range results {
+		switch r {
+		case pushed:
+			stats.Discovered.Add(1)
+		case outOfScope:
+			stats.OutOfScope.Add(1)
+		}
+	}
+}
+
+/* pause, resume or cancel a crawl; running workers stop after their current page */
+func (s *Scraper) SetCrawlState(crawlID int, state string) error {
+	res, err := s.DB.Exec(
+		`UPDATE crawls SET state = $2, updated_at = NOW() WHERE id = $1 AND state NOT IN ($3, $4)`,
+		crawlID, state, CrawlDone, CrawlCanceled,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("crawl %d not found or finished", crawlID)
+	}
+	return nil
+}
+
+/* rebuild the crawl's job from its stored options and run it */
+func (s *Scraper) ResumeCrawl(ctx context.Context, crawlID int) error {
+	var raw []byte
+	var jobID sql.NullInt64
+	err := s.DB.QueryRow(`SELECT options, job_id FROM crawls WHERE id = $1`, crawlID).Scan(&raw, &jobID)
+	if err != nil {
+		return err
+	}
+	if jobID.Valid {
+		// crawls of a job run together under the job
+		return s.runJobCrawlsQueued(ctx, int(jobID.Int64))
+	}
+	var opts JobOptions
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return err
+	}
+	job := s.WithOptions(opts)
+	return job.Run(ctx, func(ctx context.Context) {
+		job.Governed(ctx, false, func(ctx context.Context) {
+			job.runResumedCrawl(ctx, crawlID)
+		})
+	})
+}
+
+/* run a crawl again and log how far it got */
+func (s *Scraper) runResumedCrawl(ctx context.Context, crawlID int) {
+	stats := s.RunCrawl(ctx, crawlID)
+	log.Printf("crawl %d resumed: fetched=%d failed=%d skipped=%d discovered=%d out_of_scope=%d",
+		crawlID, stats.Fetched.Load(), stats.Failed.Load(), stats.Skipped.Load(),
+		stats.Discovered.Load(), stats.OutOfScope.Load())
+}
+
+/* resume crawls a shutdown or crash left running; jobs still in the queue are left to it */
+func (s *Scraper) ResumeCrawls(ctx context.Context) error {
+	rows, err := s.DB.Query(
+		`SELECT DISTINCT ON (COALESCE(job_id, -id)) id FROM crawls
+        WHERE state = $1 AND NOT EXISTS (
+            SELECT 1 FROM task_queue WHERE task_queue.job_id = crawls.job_id AND task_queue.state IN ($2, $3)
+        )
+        ORDER BY COALESCE(job_id, -id), id`,
+		CrawlRunning, database.QueuePending, database.QueueLeased,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// one crawl per job, ResumeCrawl picks up the job's other crawls
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		go func() {
+			if err := s.ResumeCrawl(ctx, id); err != nil {
+				fmt.Fprintf(os.Stderr, "resume crawl %d: %v\n", id, err)
+			}
+		}()
+	}
+	return rows.Err()
 }
@@ -0,0 +1,234 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+/* crawl and frontier row states */
+const (
+	CrawlRunning  = "running"
+	CrawlPaused   = "paused"
+	CrawlDone     = "done"
+	CrawlCanceled = "canceled"
+
+	FrontierQueued     = "queued"
+	FrontierInFlight   = "in_flight"
+	FrontierDone       = "done"
+	FrontierFailed     = "failed"
+	FrontierSkipped    = "skipped"
+	FrontierOutOfScope = "out_of_scope"
+	FrontierDuplicate  = "duplicate" // redirect target or rel=canonical of a fetched page
+)
+
+type crawlItem struct {
+	id    int64
+	url   string
+	depth int
+}
+
+/* BFS queue of (url, depth) and visited set, persisted in crawl_frontier */
+type frontier struct {
+	db       *sql.DB
+	crawlID  int
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inFlight int // claimed by this process and not done yet
+	enqueued int
+	budget   int // max urls ever enqueued, 0 means no limit
+	scope    *scopeMatcher
+}
+
+type pushResult int
+
+const (
+	pushed pushResult = iota
+	alreadySeen
+	outOfScope
+	overBudget
+)
+
+func newFrontier(db *sql.DB, crawlID, budget int) *frontier {
+	f := &frontier{db: db, crawlID: crawlID, budget: budget}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+/* requeue what a crashed or stopped run left in flight */
+func (f *frontier) recover() error {
+	_, err := f.db.Exec(
+		`UPDATE crawl_frontier SET state = $2, claimed_at = NULL, updated_at = NOW()
+        WHERE crawl_id = $1 AND state = $3`,
+		f.crawlID, FrontierQueued, FrontierInFlight,
+	)
+	return err
+}
+
+/* count urls already enqueued against the budget */
+func (f *frontier) countEnqueued() error {
+	return f.db.QueryRow(
+		`SELECT COUNT(*) FROM crawl_frontier WHERE crawl_id = $1 AND state NOT IN ($2, $3)`,
+		f.crawlID, FrontierOutOfScope, FrontierDuplicate,
+	).Scan(&f.enqueued)
+}
+
+/* links per host the scope let in on earlier runs, the start url never counted */
+func (f *frontier) countPerHost(startURL string) error {
+	rows, err := f.db.Query(
+		`SELECT lower(substring(url from '^[^:/]+://(?:[^@/]*@)?([^/:?#]+)')) AS host, COUNT(*)
+        FROM crawl_frontier WHERE crawl_id = $1 AND state NOT IN ($2, $3) AND url <> $4
+        GROUP BY host`,
+		f.crawlID, FrontierOutOfScope, FrontierDuplicate, startURL,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	f.scope.mu.Lock()
+	defer f.scope.mu.Unlock()
+	for rows.Next() {
+		var host sql.NullString
+		var n int
+		if err := rows.Scan(&host, &n); err != nil {
+			return err
+		}
+		if host.Valid {
+			f.scope.perHost[host.String] = n
+		}
+	}
+	return rows.Err()
+}
+
+/* enqueue links of one depth, each unless already visited, out of scope or over budget */
+func (f *frontier) pushAll(links []string, depth int) ([]pushResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results := make([]pushResult, len(links))
+	seen := make(map[string]bool)
+	rows, err := f.db.Query(
+		`SELECT url FROM crawl_frontier WHERE crawl_id = $1 AND url = ANY($2)`,
+		f.crawlID, pq.Array(links),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		seen[u] = true
+	}
+	rows.Close()
+
+	var queued, outside []string
+	for i, link := range links {
+		switch {
+		case seen[link]:
+			results[i] = alreadySeen
+			continue
+		case f.budget > 0 && f.enqueued >= f.budget:
+			results[i] = overBudget
+			continue
+		case f.scope != nil && !f.scope.allows(link):
+			// remembered so every out of scope url is counted once
+			results[i] = outOfScope
+			outside = append(outside, link)
+		default:
+			results[i] = pushed
+			queued = append(queued, link)
+			f.enqueued++
+		}
+		seen[link] = true
+	}
+
+	if err := f.insert(queued, depth, FrontierQueued); err != nil {
+		return nil, err
+	}
+	if err := f.insert(outside, depth, FrontierOutOfScope); err != nil {
+		return nil, err
+	}
+	if len(queued) > 0 {
+		f.cond.Broadcast()
+	}
+	return results, nil
+}
+
+func (f *frontier) insert(urls []string, depth int, state string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	_, err := f.db.Exec(
+		`INSERT INTO crawl_frontier (crawl_id, url, depth, state)
+        SELECT $1::int, u, $3::int, $4::text FROM unnest($2::text[]) AS u
+        ON CONFLICT (crawl_id, url) DO NOTHING`,
+		f.crawlID, pq.Array(urls), depth, state,
+	)
+	return err
+}
+
+/* mark a url seen without fetching it */
+func (f *frontier) markVisited(u string, depth int) error {
+	return f.insert([]string{u}, depth, FrontierDuplicate)
+}
+
+/* claim the shallowest queued url, false once the crawl is exhausted, paused or canceled */
+func (f *frontier) next(ctx context.Context) (crawlItem, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ctx.Err() == nil {
+		var item crawlItem
+		err := f.db.QueryRowContext(ctx,
+			`UPDATE crawl_frontier SET state = $2, claimed_at = NOW(), updated_at = NOW()
+            WHERE id = (
+                SELECT fr.id FROM crawl_frontier fr
+                JOIN crawls c ON c.id = fr.crawl_id
+                WHERE fr.crawl_id = $1 AND fr.state = $3 AND c.state = $4
+                ORDER BY fr.depth, fr.id
+                LIMIT 1
+                FOR UPDATE OF fr SKIP LOCKED
+            )
+            RETURNING id, url, depth`,
+			f.crawlID, FrontierInFlight, FrontierQueued, CrawlRunning,
+		).Scan(&item.id, &item.url, &item.depth)
+		if err == nil {
+			f.inFlight++
+			return item, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return crawlItem{}, false, err
+		}
+		if f.inFlight == 0 {
+			return crawlItem{}, false, nil
+		}
+		// others are still fetching and may enqueue links
+		f.cond.Wait()
+	}
+	return crawlItem{}, false, nil
+}
+
+/* store the outcome of a claimed item */
+func (f *frontier) done(item crawlItem, state, errMsg string) error {
+	_, err := f.db.Exec(
+		`UPDATE crawl_frontier SET state = $2, error = NULLIF($3, ''), updated_at = NOW() WHERE id = $1`,
+		item.id, state, errMsg,
+	)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.cond.Broadcast()
+	f.mu.Unlock()
+	return err
+}
+
+func (f *frontier) wake() {
+	f.mu.Lock()
+	f.cond.Broadcast()
+	f.mu.Unlock()
+}
@@ -0,0 +1,232 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrBacklogFull = errors.New("scrape backlog full")
+
+/* process-wide admission of scrapes and crawls in arrival order, and of fetches per host; 0 means no limit */
+type Governor struct {
+	MaxInFlight int // scrapes and crawls running at once
+	MaxPerHost  int // requests to one host at once, whichever scrape or crawl sends them
+	MaxBacklog  int
+
+	mu          sync.Mutex
+	inFlight    int
+	waiting     []chan struct{}
+	reserved    int // accepted by the API, not waiting yet
+	hosts       map[string]int
+	hostWaiting map[string][]chan struct{}
+}
+
+/* snapshot of the governor */
+type GovernorMetrics struct {
+	InFlight     int            `json:"in_flight"`
+	Waiting      int            `json:"waiting"`
+	Reserved     int            `json:"reserved"`
+	Backlog      int            `json:"backlog"`
+	MaxInFlight  int            `json:"max_in_flight"`
+	MaxPerHost   int            `json:"max_per_host"`
+	MaxBacklog   int            `json:"max_backlog"`
+	Hosts        map[string]int `json:"hosts"` // requests in flight per host
+	HostsWaiting int            `json:"hosts_waiting"`
+}
+
+func NewGovernor(maxInFlight, maxPerHost, maxBacklog int) *Governor {
+	return &Governor{
+		MaxInFlight: maxInFlight,
+		MaxPerHost:  maxPerHost,
+		MaxBacklog:  maxBacklog,
+		hosts:       make(map[string]int),
+		hostWaiting: make(map[string][]chan struct{}),
+	}
+}
+
+/* reserve n places in the backlog, returns the queue position of the first one */
+func (g *Governor) Reserve(n int) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	backlog := g.reserved + len(g.waiting)
+	if g.MaxBacklog > 0 && backlog+n > g.MaxBacklog {
+		return backlog, ErrBacklogFull
+	}
+	g.reserved += n
+	return backlog + 1, nil
+}
+
+/* give back reservations that won't be acquired */
+func (g *Governor) Unreserve(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reserved = max(g.reserved-n, 0)
+}
+
+/* wait for a global slot; reserved work uses up one reservation */
+func (g *Governor) Acquire(ctx context.Context, reserved bool) (func(), error) {
+	ready := make(chan struct{})
+
+	g.mu.Lock()
+	if reserved {
+		g.reserved = max(g.reserved-1, 0)
+	}
+	g.waiting = append(g.waiting, ready)
+	g.grant()
+	g.mu.Unlock()
+
+	select {
+	case <-ready:
+		var once sync.Once
+		return func() { once.Do(g.release) }, nil
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	select {
+	case <-ready:
+		// granted while giving up
+		g.mu.Unlock()
+		g.release()
+		return nil, ctx.Err()
+	default:
+	}
+	g.waiting = removeWaiter(g.waiting, ready)
+	g.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+func (g *Governor) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	g.grant()
+}
+
+/* start waiters in order while there is room; caller holds mu */
+func (g *Governor) grant() {
+	for len(g.waiting) > 0 && (g.MaxInFlight <= 0 || g.inFlight < g.MaxInFlight) {
+		g.inFlight++
+		close(g.waiting[0])
+		g.waiting[0] = nil
+		g.waiting = g.waiting[1:]
+	}
+}
+
+/* wait until a request to host may be sent, in arrival order per host */
+func (g *Governor) AcquireHost(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	ready := make(chan struct{})
+
+	g.mu.Lock()
+	if g.MaxPerHost <= 0 || g.hosts[host] < g.MaxPerHost {
+		g.hosts[host]++
+		close(ready)
+	} else {
+		g.hostWaiting[host] = append(g.hostWaiting[host], ready)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-ready:
+		var once sync.Once
+		return func() { once.Do(func() { g.releaseHost(host) }) }, nil
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	select {
+	case <-ready:
+		// granted while giving up
+		g.mu.Unlock()
+		g.releaseHost(host)
+		return nil, ctx.Err()
+	default:
+	}
+	if waiting := removeWaiter(g.hostWaiting[host], ready); len(waiting) > 0 {
+		g.hostWaiting[host] = waiting
+	} else {
+		delete(g.hostWaiting, host)
+	}
+	g.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+/* hand the host's slot to its next waiter or free it */
+func (g *Governor) releaseHost(host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if waiting := g.hostWaiting[host]; len(waiting) > 0 {
+		close(waiting[0])
+		if len(waiting) == 1 {
+			delete(g.hostWaiting, host)
+		} else {
+			g.hostWaiting[host] = waiting[1:]
+		}
+		return
+	}
+	if g.hosts[host]--; g.hosts[host] <= 0 {
+		delete(g.hosts, host)
+	}
+}
+
+func removeWaiter(waiting []chan struct{}, ready chan struct{}) []chan struct{} {
+	for i, w := range waiting {
+		if w == ready {
+			return append(waiting[:i], waiting[i+1:]...)
+		}
+	}
+	return waiting
+}
+
+func (g *Governor) Metrics() GovernorMetrics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m := GovernorMetrics{
+		InFlight:    g.inFlight,
+		Waiting:     len(g.waiting),
+		Reserved:    g.reserved,
+		Backlog:     g.reserved + len(g.waiting),
+		MaxInFlight: g.MaxInFlight,
+		MaxPerHost:  g.MaxPerHost,
+		MaxBacklog:  g.MaxBacklog,
+		Hosts:       make(map[string]int, len(g.hosts)),
+	}
+	for h, n := range g.hosts {
+		m.Hosts[h] = n
+	}
+	for _, waiting := range g.hostWaiting {
+		m.HostsWaiting += len(waiting)
+	}
+	return m
+}
+
+/* run work once the governor admits it */
+func (s *Scraper) Governed(ctx context.Context, reserved bool, work func(ctx context.Context)) error {
+	release, err := s.Governor.Acquire(ctx, reserved)
+	if err != nil {
+		return err
+	}
+	defer release()
+	work(ctx)
+	return nil
+}
+
+/* send a request once host has room, the wait doesn't hold a pool slot */
+func (s *Scraper) hostSlot(ctx context.Context, host string) (func(), error) {
+	if s.Governor == nil {
+		return func() {}, nil
+	}
+	var release func()
+	err := idleWait(ctx, func() error {
+		var err error
+		release, err = s.Governor.AcquireHost(ctx, host)
+		return err
+	})
+	if err != nil && release != nil {
+		release()
+	}
+	return release, err
+}
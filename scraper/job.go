@@ -0,0 +1,299 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"webScraper/database"
+
+	"github.com/lib/pq"
+)
+
+/* scrape job states */
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobPaused    = "paused"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+/* states a job may move to a state from; running to running is a resume after restart, failed to queued a retry */
+var jobTransitions = map[string][]string{
+	JobQueued:    {JobFailed},
+	JobRunning:   {JobQueued, JobPaused, JobRunning},
+	JobPaused:    {JobRunning, JobPaused},
+	JobSucceeded: {JobRunning, JobPaused},
+	JobFailed:    {JobQueued, JobRunning, JobPaused},
+	JobCanceled:  {JobQueued, JobRunning, JobPaused},
+}
+
+/* process shutdown, as opposed to a timeout or a canceled job */
+var ErrShutdown = errors.New("shutting down")
+
+/* one bulk request and what became of it */
+type Job struct {
+	ID         int             `json:"id"`
+	Request    json.RawMessage `json:"request"`
+	State      string          `json:"state"`
+	Error      string          `json:"error,omitempty"`
+	Fetched    int64           `json:"fetched"`
+	Failed     int64           `json:"failed"`
+	Skipped    int64           `json:"skipped"`
+	Bytes      int64           `json:"bytes"`
+	Cache      JobCache        `json:"cache"`
+	CrawlIDs   []int64         `json:"crawl_ids,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+/* cache outcomes of a job's pages */
+type JobCache struct {
+	Hits        int64 `json:"hits"`
+	Revalidated int64 `json:"revalidated"`
+	Misses      int64 `json:"misses"`
+}
+
+/* store a queued job with its request parameters */
+func CreateJob(db *sql.DB, request any) (int, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = db.QueryRow(
+		`INSERT INTO scrape_jobs (request, state) VALUES ($1, $2) RETURNING id`,
+		raw, JobQueued,
+	).Scan(&id)
+	return id, err
+}
+
+func LoadJob(db *sql.DB, id int) (*Job, error) {
+	j := &Job{ID: id}
+	var startedAt, finishedAt sql.NullTime
+	err := db.QueryRow(
+		`SELECT request, state, COALESCE(error, ''), fetched, failed, skipped, bytes,
+            cache_hits, cache_revalidated, cache_misses, created_at, started_at, finished_at, updated_at
+        FROM scrape_jobs WHERE id = $1`,
+		id,
+	).Scan(&j.Request, &j.State, &j.Error, &j.Fetched, &j.Failed, &j.Skipped, &j.Bytes,
+		&j.Cache.Hits, &j.Cache.Revalidated, &j.Cache.Misses, &j.CreatedAt, &startedAt, &finishedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+
+	err = db.QueryRow(`SELECT COALESCE(array_agg(id ORDER BY id), '{}') FROM crawls WHERE job_id = $1`, id).
+		Scan(pq.Array(&j.CrawlIDs))
+	return j, err
+}
+
+/* move a job along its state machine, fails if the current state doesn't allow it */
+func SetJobState(db *sql.DB, id int, state, errMsg string) error {
+	from, ok := jobTransitions[state]
+	if !ok {
+		return fmt.Errorf("unknown job state %q", state)
+	}
+	res, err := db.Exec(
+		`UPDATE scrape_jobs SET state = $2, error = NULLIF($3, ''), updated_at = NOW(),
+            started_at = CASE WHEN $2 = 'running' THEN COALESCE(started_at, NOW()) ELSE started_at END,
+            finished_at = CASE WHEN $2 IN ('succeeded', 'failed', 'canceled') THEN NOW()
+                WHEN $2 = 'queued' THEN NULL ELSE finished_at END
+        WHERE id = $1 AND state = ANY($4)`,
+		id, state, errMsg, pq.Array(from),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("job %d not found or cannot become %s", id, state)
+	}
+	return nil
+}
+
+func JobState(db *sql.DB, id int) (string, error) {
+	var state string
+	err := db.QueryRow(`SELECT state FROM scrape_jobs WHERE id = $1`, id).Scan(&state)
+	return state, err
+}
+
+/* live counters and pause gate of the job a scraper works for */
+type jobTracker struct {
+	id      int
+	gate    *PauseGate
+	fetched atomic.Int64
+	failed  atomic.Int64
+	skipped atomic.Int64
+	bytes   atomic.Int64
+
+	cacheHits        atomic.Int64
+	cacheRevalidated atomic.Int64
+	cacheMisses      atomic.Int64
+}
+
+/* count the outcome of one page */
+func (t *jobTracker) count(res *fetchResult, err error) {
+	if t == nil {
+		return
+	}
+	switch _, skip := skipReason(err); {
+	case skip:
+		t.skipped.Add(1)
+	case err != nil:
+		t.failed.Add(1)
+	default:
+		t.fetched.Add(1)
+		if !res.NotModified && !res.FromCache {
+			t.bytes.Add(int64(len(res.Body)))
+		}
+		switch {
+		case res.FromCache:
+			t.cacheHits.Add(1)
+		case res.NotModified:
+			t.cacheRevalidated.Add(1)
+		case res.CacheMiss:
+			t.cacheMisses.Add(1)
+		}
+	}
+}
+
+/* add counts since the last flush to the job row */
+func (t *jobTracker) flush(db *sql.DB) {
+	fetched, failed := t.fetched.Swap(0), t.failed.Swap(0)
+	skipped, bytes := t.skipped.Swap(0), t.bytes.Swap(0)
+	hits, revalidated, misses := t.cacheHits.Swap(0), t.cacheRevalidated.Swap(0), t.cacheMisses.Swap(0)
+	if fetched+failed+skipped+bytes == 0 {
+		return
+	}
+	_, err := db.Exec(
+		`UPDATE scrape_jobs SET fetched = fetched + $2, failed = failed + $3, skipped = skipped + $4,
+            bytes = bytes + $5, cache_hits = cache_hits + $6, cache_revalidated = cache_revalidated + $7,
+            cache_misses = cache_misses + $8, updated_at = NOW()
+        WHERE id = $1`,
+		t.id, fetched, failed, skipped, bytes, hits, revalidated, misses,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "job %d counters: %v\n", t.id, err)
+	}
+}
+
+/* hold while the job is paused */
+func (t *jobTracker) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.gate.Wait(ctx)
+}
+
+func (t *jobTracker) jobID() sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(t.id), Valid: true}
+}
+
+/* copy of the scraper whose pages are counted and stored for job id */
+func (s *Scraper) ForJob(id int) *Scraper {
+	c := *s
+	c.job = &jobTracker{id: id, gate: &PauseGate{}}
+	return &c
+}
+
+/* run the job through its lifecycle: running, then succeeded, failed, paused or canceled */
+func (s *Scraper) RunJob(ctx context.Context, work func(ctx context.Context)) {
+	if s.job == nil {
+		s.Run(ctx, work)
+		return
+	}
+	id := s.job.id
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	h := &jobHandle{cancel: cancel, gate: s.job.gate, done: make(chan struct{})}
+	if _, running := runningJobs.LoadOrStore(id, h); running {
+		return
+	}
+	defer func() {
+		runningJobs.Delete(id)
+		close(h.done)
+	}()
+
+	if err := SetJobState(s.DB, id, JobRunning, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "job %d: %v\n", id, err)
+		return
+	}
+
+	// counters show up while the job runs
+	flushCtx, stopFlush := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.job.flush(s.DB)
+			case <-flushCtx.Done():
+				return
+			}
+		}
+	}()
+
+	err := s.Run(ctx, work)
+	stopFlush()
+	s.job.flush(s.DB)
+
+	state, errMsg := JobSucceeded, ""
+	switch {
+	case errors.Is(context.Cause(ctx), database.ErrLeaseLost):
+		// the queue gave the job to another worker, which records its state
+		return
+	case err != nil:
+		state, errMsg = JobFailed, err.Error()
+	case errors.Is(context.Cause(ctx), ErrShutdown):
+		if s.jobHasCrawls(id, CrawlRunning) {
+			// picked up again by ResumeCrawls on the next start
+			return
+		}
+		state, errMsg = JobFailed, "interrupted by shutdown"
+	case ctx.Err() == nil && s.jobHasCrawls(id, CrawlRunning):
+		// another run of the job's crawls finishes it
+		return
+	case ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		if s.jobHasCrawls(id, CrawlPaused) {
+			state = JobPaused
+		} else if current, _ := JobState(s.DB, id); current == JobPaused && s.Queue != nil {
+			// timed out while paused, ResumeJob queues it again
+			state = JobPaused
+		} else if ctx.Err() != nil {
+			state, errMsg = JobFailed, "timed out"
+		}
+	default:
+		if current, _ := JobState(s.DB, id); current == JobCanceled {
+			// canceled through another process
+			return
+		}
+		state = JobCanceled
+	}
+	if err := SetJobState(s.DB, id, state, errMsg); err != nil {
+		fmt.Fprintf(os.Stderr, "job %d: %v\n", id, err)
+	}
+}
+
+func (s *Scraper) jobHasCrawls(id int, state string) bool {
+	var exists bool
+	err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM crawls WHERE job_id = $1 AND state = $2)`, id, state).Scan(&exists)
+	return err == nil && exists
+}
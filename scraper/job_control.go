@@ -0,0 +1,265 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/* cancel cause of a job canceled through the API */
+var ErrJobCanceled = errors.New("job canceled")
+
+/* blocks fetches while a job is paused */
+type PauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{}
+}
+
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resume = make(chan struct{})
+	}
+}
+
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resume)
+	}
+}
+
+/* wait until the gate is open or ctx ends */
+func (g *PauseGate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	paused, resume := g.paused, g.resume
+	g.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* a job running in this process */
+type jobHandle struct {
+	cancel context.CancelCauseFunc
+	gate   *PauseGate
+	done   chan struct{} // closed when the run returns
+}
+
+/* jobs running in this process by id */
+var runningJobs sync.Map
+
+func runningJob(id int) (*jobHandle, bool) {
+	h, ok := runningJobs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return h.(*jobHandle), true
+}
+
+/* whether the job runs in this process */
+func JobActive(id int) bool {
+	_, ok := runningJobs.Load(id)
+	return ok
+}
+
+/* stop fetching new pages; crawls pause after their current page and resume from the frontier */
+func PauseJob(db *sql.DB, id int) error {
+	if err := SetJobState(db, id, JobPaused, ""); err != nil {
+		return err
+	}
+	if h, ok := runningJob(id); ok {
+		h.gate.Pause()
+	}
+	return setJobCrawlStates(db, id, CrawlPaused, CrawlRunning)
+}
+
+/* stop the job for good, pages stored so far are kept */
+func CancelJob(db *sql.DB, id int) error {
+	if err := setJobCrawlStates(db, id, CrawlCanceled, CrawlRunning, CrawlPaused); err != nil {
+		return err
+	}
+	if h, ok := runningJob(id); ok {
+		// RunJob records the canceled state once the goroutines are gone
+		h.cancel(ErrJobCanceled)
+		return nil
+	}
+	return SetJobState(db, id, JobCanceled, "")
+}
+
+/* continue a paused job: open its gate, or run its paused crawls again */
+func (s *Scraper) ResumeJob(ctx context.Context, id int) error {
+	var crawls int
+	err := s.DB.QueryRow(
+		`SELECT COUNT(*) FROM crawls WHERE job_id = $1 AND state IN ($2, $3)`,
+		id, CrawlPaused, CrawlRunning,
+	).Scan(&crawls)
+	if err != nil {
+		return err
+	}
+
+	h, running := runningJob(id)
+	if crawls == 0 {
+		// a job run by a queue worker is picked up by SyncJob
+		if !running && s.Queue == nil {
+			return fmt.Errorf("job %d has nothing to resume", id)
+		}
+		if err := SetJobState(s.DB, id, JobRunning, ""); err != nil {
+			return err
+		}
+		if running {
+			h.gate.Resume()
+			return nil
+		}
+		if err := s.requeueJob(id); err != nil {
+			SetJobState(s.DB, id, JobPaused, "")
+			return err
+		}
+		return nil
+	}
+
+	// a paused crawl job finishes its run first, then continues from its frontier
+	if err := SetJobState(s.DB, id, JobRunning, ""); err != nil {
+		return err
+	}
+	if s.Queue != nil && !running {
+		// back to a worker, unless one still runs the job
+		if err := s.runJobCrawlsQueued(ctx, id); err != nil {
+			SetJobState(s.DB, id, JobPaused, "")
+			return err
+		}
+		return nil
+	}
+	go func() {
+		if running {
+			h.gate.Resume()
+			<-h.done
+		}
+		if err := s.runJobCrawls(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "resume job %d: %v\n", id, err)
+		}
+	}()
+	return nil
+}
+
+/* a worker still running the job resumes it through SyncJob, otherwise it starts over */
+func (s *Scraper) requeueJob(id int) error {
+	open, err := s.Queue.HasOpenTask(id)
+	if err != nil || open {
+		return err
+	}
+	requeued, err := s.Queue.Requeue(id, TaskBulkJob)
+	if err == nil && !requeued {
+		err = fmt.Errorf("job %d has nothing to resume", id)
+	}
+	return err
+}
+
+/* apply a pause, resume or cancel made through another process to the job running here */
+func SyncJob(db *sql.DB, id int) error {
+	h, ok := runningJob(id)
+	if !ok {
+		return nil
+	}
+	state, err := JobState(db, id)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case JobPaused:
+		h.gate.Pause()
+	case JobRunning:
+		h.gate.Resume()
+	case JobCanceled:
+		h.cancel(ErrJobCanceled)
+	}
+	return nil
+}
+
+/* run all unfinished crawls of a job under one job run */
+func (s *Scraper) runJobCrawls(ctx context.Context, id int) error {
+	rows, err := s.DB.Query(
+		`SELECT id, options FROM crawls WHERE job_id = $1 AND state IN ($2, $3) ORDER BY id`,
+		id, CrawlPaused, CrawlRunning,
+	)
+	if err != nil {
+		return err
+	}
+	var crawlIDs []int
+	var raw []byte
+	for rows.Next() {
+		var crawlID int
+		if err := rows.Scan(&crawlID, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		crawlIDs = append(crawlIDs, crawlID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(crawlIDs) == 0 {
+		return err
+	}
+
+	// crawls of one job share its options
+	var opts JobOptions
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return err
+	}
+	if err := setJobCrawlStates(s.DB, id, CrawlRunning, CrawlPaused); err != nil {
+		return err
+	}
+	job := s.WithOptions(opts).ForJob(id)
+	job.RunJob(ctx, func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, crawlID := range crawlIDs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				job.Governed(ctx, false, func(ctx context.Context) {
+					job.runResumedCrawl(ctx, crawlID)
+				})
+			}()
+		}
+		wg.Wait()
+	})
+	return nil
+}
+
+/* run the job's unfinished crawls on a queue worker, or here without a queue */
+func (s *Scraper) runJobCrawlsQueued(ctx context.Context, id int) error {
+	if s.Queue == nil {
+		return s.runJobCrawls(ctx, id)
+	}
+	// weightless, resuming a job already counted doesn't wait for room
+	_, _, err := s.Queue.Enqueue(TaskResumeJob, sql.NullInt64{Int64: int64(id), Valid: true}, 0, resumeTask{JobID: id})
+	return err
+}
+
+/* move the job's crawls in one of from to state */
+func setJobCrawlStates(db *sql.DB, id int, state string, from ...string) error {
+	for _, f := range from {
+		_, err := db.Exec(
+			`UPDATE crawls SET state = $2, updated_at = NOW() WHERE job_id = $1 AND state = $3`,
+			id, state, f,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+/* login run before Scrape/ScrapeWithDepth, credentials are references only */
+type LoginStep struct {
+	Type          string            `json:"type"` // basic, bearer or form
+	URL           string            `json:"url"`  // form: login page, basic/bearer: host the header is sent to
+	Username      string            `json:"username"`
+	Password      string            `json:"password"`
+	Token         string            `json:"token"`
+	FormSelector  string            `json:"form_selector"`
+	UsernameField string            `json:"username_field"`
+	PasswordField string            `json:"password_field"`
+	Fields        map[string]string `json:"fields"`
+}
+
+/* check type and that credentials are env:/file: references */
+func (l *LoginStep) Validate() error {
+	u, err := url.Parse(l.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("login: invalid url %q", l.URL)
+	}
+
+	var refs []string
+	switch l.Type {
+	case "basic", "form":
+		refs = []string{l.Username, l.Password}
+	case "bearer":
+		refs = []string{l.Token}
+	default:
+		return fmt.Errorf("login: unknown type %q", l.Type)
+	}
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref, "env:") && !strings.HasPrefix(ref, "file:") {
+			return fmt.Errorf("login: credentials must be env: or file: references")
+		}
+	}
+	return nil
+}
+
+/* resolve env:NAME or file:/run/secrets/name */
+func ResolveCredential(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("credential env %s not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(ref, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("credential file: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unsupported credential reference")
+	}
+}
+
+/* run the job's login step */
+func (s *Scraper) Login(ctx context.Context) error {
+	step := s.Options.Login
+	if step == nil {
+		return nil
+	}
+	if err := step.Validate(); err != nil {
+		return err
+	}
+	u, _ := url.Parse(step.URL)
+
+	switch step.Type {
+	case "basic":
+		user, err := ResolveCredential(step.Username)
+		if err != nil {
+			return err
+		}
+		pass, err := ResolveCredential(step.Password)
+		if err != nil {
+			return err
+		}
+		s.auth = &authHeader{
+			host:  u.Host,
+			value: "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass)),
+		}
+		return nil
+	case "bearer":
+		token, err := ResolveCredential(step.Token)
+		if err != nil {
+			return err
+		}
+		s.auth = &authHeader{host: u.Host, value: "Bearer " + token}
+		return nil
+	default:
+		return s.formLogin(ctx, step, u)
+	}
+}
+
+/* GET login page, fill the form incl. hidden CSRF fields, POST it */
+func (s *Scraper) formLogin(ctx context.Context, step *LoginStep, u *url.URL) error {
+	user, err := ResolveCredential(step.Username)
+	if err != nil {
+		return err
+	}
+	pass, err := ResolveCredential(step.Password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Limiter.Wait(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	pr, err := s.doRequest(ctx, u, nil)
+	if err != nil {
+		return fmt.Errorf("login page: %w", err)
+	}
+	if pr.resp.StatusCode >= 400 {
+		return fmt.Errorf("login page: status %d", pr.resp.StatusCode)
+	}
+	pageURL := pr.resp.Request.URL
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(pr.body))
+	if err != nil {
+		return fmt.Errorf("login page: %w", err)
+	}
+	form := doc.Find("form:has(input[type=password])").First()
+	if step.FormSelector != "" {
+		form = doc.Find(step.FormSelector).First()
+	}
+	if form.Length() == 0 {
+		return fmt.Errorf("login: no login form on %s", pageURL)
+	}
+
+	values := url.Values{}
+	form.Find("input[name]").Each(func(i int, in *goquery.Selection) {
+		name, _ := in.Attr("name")
+		value, _ := in.Attr("value")
+		switch strings.ToLower(in.AttrOr("type", "text")) {
+		case "submit", "button", "image", "file":
+			return
+		case "checkbox", "radio":
+			if _, checked := in.Attr("checked"); !checked {
+				return
+			}
+		}
+		values.Set(name, value)
+	})
+
+	userField, passField := step.UsernameField, step.PasswordField
+	if userField == "" {
+		userField = "username"
+	}
+	if passField == "" {
+		passField = form.Find("input[type=password]").First().AttrOr("name", "password")
+	}
+	values.Set(userField, user)
+	values.Set(passField, pass)
+	for k, v := range step.Fields {
+		values.Set(k, v)
+	}
+
+	action, err := pageURL.Parse(form.AttrOr("action", ""))
+	if err != nil {
+		return fmt.Errorf("login: invalid form action: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", action.String(), strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	s.setRequestHeaders(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", pageURL.String())
+
+	if err := s.Limiter.Wait(ctx, action.Hostname()); err != nil {
+		return err
+	}
+	postResp, _, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	postResp.Body.Close()
+	if postResp.StatusCode >= 400 {
+		return fmt.Errorf("login: status %d", postResp.StatusCode)
+	}
+	return nil
+}
+
+/* Authorization header, only sent to the login host */
+type authHeader struct {
+	host  string
+	value string
+}
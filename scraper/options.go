@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+/* per job settings on top of the shared scraper */
+type JobOptions struct {
+	Headers        map[string]string
+	AcceptLanguage string
+	Referer        string
+	Session        string // name the cookie jar is persisted under
+	Cookies        []SessionCookie
+	Login          *LoginStep
+	Timeout        time.Duration     // per request, overrides Scraper.Timeout
+	MaxTotalPages  int               // depth crawl page budget, 0 means no limit
+	Scope          *Scope            // depth crawl scope, nil means DefaultScope
+	Pagination     *PaginationConfig // listing pages of Scrape, nil means ?page=N
+	Cache          string            // CacheOff, CacheRevalidate (default) or CachePrefer
+	TaskTimeout    time.Duration     // whole page with retries, 0 means none
+}
+
+/* copy of the scraper for one job, sharing transport, limiter and robots cache */
+func (s *Scraper) WithOptions(opts JobOptions) *Scraper {
+	c := *s
+	c.Options = opts
+	c.auth = nil
+	c.cacheStats = &CacheStats{}
+
+	// own cookie jar so all pages of the job are one browsing session
+	c.jar = NewSessionJar()
+	client := *s.client
+	client.Jar = c.jar
+	client.CheckRedirect = c.checkRedirect
+	if opts.Timeout > 0 {
+		client.Timeout = opts.Timeout
+	}
+	c.client = &client
+
+	c.headers = make(http.Header)
+	for k, v := range opts.Headers {
+		if http.CanonicalHeaderKey(k) == "User-Agent" {
+			// robots.txt has to be matched against the agent we send
+			c.UserAgent = v
+			continue
+		}
+		c.headers.Set(k, v)
+	}
+	if opts.AcceptLanguage != "" {
+		c.headers.Set("Accept-Language", opts.AcceptLanguage)
+	}
+	if opts.Referer != "" {
+		c.headers.Set("Referer", opts.Referer)
+	}
+	return &c
+}
+
+/* run work inside the job's session: load cookies, log in, persist cookies afterwards */
+func (s *Scraper) Run(ctx context.Context, work func(ctx context.Context)) error {
+	if err := s.StartSession(); err != nil {
+		fmt.Fprintf(os.Stderr, "session %q: %v\n", s.Options.Session, err)
+	}
+	defer func() {
+		if err := s.SaveSession(); err != nil {
+			fmt.Fprintf(os.Stderr, "save session %q: %v\n", s.Options.Session, err)
+		}
+	}()
+
+	if err := s.Login(ctx); err != nil {
+		return err
+	}
+	work(ctx)
+	log.Printf("job cache: %s", s.cacheStats)
+	return nil
+}
+
+/* timeout of a single request */
+func (s *Scraper) requestTimeout() time.Duration {
+	if s.Options.Timeout > 0 {
+		return s.Options.Timeout
+	}
+	return time.Duration(s.Timeout) * time.Second
+}
+
+/* headers sent with every page request */
+func (s *Scraper) setRequestHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", s.UserAgent)
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	if s.auth != nil && req.URL.Host == s.auth.host {
+		req.Header.Set("Authorization", s.auth.value)
+	}
+}
+
+/* job request headers as stored with raw_html rows */
+func (s *Scraper) requestHeaderMap() map[string]string {
+	m := map[string]string{"User-Agent": s.UserAgent}
+	for k := range s.headers {
+		m[k] = s.headers.Get(k)
+	}
+	return m
+}
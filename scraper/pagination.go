@@ -0,0 +1,196 @@
+package scraper
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"webScraper/parser"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+/* a fetched page of a listing, input for the next page url */
+type Page struct {
+	URL        string
+	StatusCode int
+	Body       []byte
+	Encoding   string
+}
+
+/* how Scrape gets from one listing page to the next */
+type Paginator interface {
+	// url of page n (1 based), prev is page n-1 or nil; false ends the listing
+	NextURL(start *url.URL, n int, prev *Page) (string, bool)
+	// pages depend on the previous one and are fetched one after another
+	Sequential() bool
+}
+
+/* ?page=N style, other query params are kept */
+type QueryParamPaginator struct {
+	Param string
+	Start int // value of the first page
+	Step  int // default 1
+}
+
+func (p QueryParamPaginator) NextURL(start *url.URL, n int, prev *Page) (string, bool) {
+	step := cmp.Or(p.Step, 1)
+	return withQuery(start, map[string]string{
+		cmp.Or(p.Param, "page"): strconv.Itoa(p.Start + (n-1)*step),
+	}), true
+}
+
+func (p QueryParamPaginator) Sequential() bool { return false }
+
+/* ?offset=0&limit=20 style */
+type OffsetPaginator struct {
+	OffsetParam string // default "offset"
+	LimitParam  string // default "limit"
+	Limit       int
+	Start       int // offset of the first page
+}
+
+func (p OffsetPaginator) NextURL(start *url.URL, n int, prev *Page) (string, bool) {
+	if p.Limit <= 0 {
+		return "", false
+	}
+	return withQuery(start, map[string]string{
+		cmp.Or(p.OffsetParam, "offset"): strconv.Itoa(p.Start + (n-1)*p.Limit),
+		cmp.Or(p.LimitParam, "limit"):   strconv.Itoa(p.Limit),
+	}), true
+}
+
+func (p OffsetPaginator) Sequential() bool { return false }
+
+/* url template with {page} and {offset}, e.g. https://shop.example/list/page/{page} */
+type TemplatePaginator struct {
+	Template string
+	Start    int // {page} of the first page
+	Step     int // {page} increment, default 1
+	PageSize int // {offset} increment, {offset} of the first page is 0
+}
+
+func (p TemplatePaginator) NextURL(start *url.URL, n int, prev *Page) (string, bool) {
+	page := p.Start + (n-1)*cmp.Or(p.Step, 1)
+	r := strings.NewReplacer(
+		"{page}", strconv.Itoa(page),
+		"{offset}", strconv.Itoa((n-1)*p.PageSize),
+	)
+	next, err := start.Parse(r.Replace(p.Template))
+	if err != nil {
+		return "", false
+	}
+	return next.String(), true
+}
+
+func (p TemplatePaginator) Sequential() bool { return false }
+
+/* follow the "next" link of each page until there is none */
+type NextLinkPaginator struct {
+	Selector string // default a[rel~=next], link[rel~=next]
+}
+
+func (p NextLinkPaginator) NextURL(start *url.URL, n int, prev *Page) (string, bool) {
+	if n == 1 {
+		return start.String(), true
+	}
+	if prev == nil {
+		return "", false
+	}
+	html := prev.Body
+	if utf8HTML, err := parser.ToUTF8(html, prev.Encoding); err == nil {
+		html = utf8HTML
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return "", false
+	}
+	href, ok := doc.Find(cmp.Or(p.Selector, "a[rel~=next], link[rel~=next]")).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return "", false
+	}
+	base, err := url.Parse(prev.URL)
+	if err != nil {
+		return "", false
+	}
+	next, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	next.Fragment = ""
+	return next.String(), true
+}
+
+func (p NextLinkPaginator) Sequential() bool { return true }
+
+/* job setting picking a paginator, Type is query, offset, template or next */
+type PaginationConfig struct {
+	Type        string `json:"type"`
+	Param       string `json:"param"`
+	Start       *int   `json:"start"` // first page number or offset, default 1 for pages and 0 for offsets
+	Step        int    `json:"step"`
+	PageSize    int    `json:"page_size"` // template {offset} increment
+	OffsetParam string `json:"offset_param"`
+	LimitParam  string `json:"limit_param"`
+	Limit       int    `json:"limit"`
+	Template    string `json:"template"`
+	Selector    string `json:"selector"`
+}
+
+func (c PaginationConfig) Paginator() (Paginator, error) {
+	firstPage, firstOffset := 1, 0
+	if c.Start != nil {
+		firstPage, firstOffset = *c.Start, *c.Start
+	}
+	switch c.Type {
+	case "", "query":
+		return QueryParamPaginator{Param: c.Param, Start: firstPage, Step: c.Step}, nil
+	case "offset":
+		if c.Limit <= 0 {
+			return nil, fmt.Errorf("pagination: offset needs a limit")
+		}
+		return OffsetPaginator{OffsetParam: c.OffsetParam, LimitParam: c.LimitParam, Limit: c.Limit, Start: firstOffset}, nil
+	case "template":
+		if !strings.Contains(c.Template, "{page}") && !strings.Contains(c.Template, "{offset}") {
+			return nil, fmt.Errorf("pagination: template needs {page} or {offset}")
+		}
+		if strings.Contains(c.Template, "{offset}") && c.PageSize <= 0 {
+			return nil, fmt.Errorf("pagination: template with {offset} needs a page_size")
+		}
+		return TemplatePaginator{Template: c.Template, Start: firstPage, Step: c.Step, PageSize: c.PageSize}, nil
+	case "next":
+		if c.Selector != "" {
+			if _, err := cascadia.ParseGroup(c.Selector); err != nil {
+				return nil, fmt.Errorf("pagination: selector %q: %w", c.Selector, err)
+			}
+		}
+		return NextLinkPaginator{Selector: c.Selector}, nil
+	default:
+		return nil, fmt.Errorf("pagination: unknown type %q", c.Type)
+	}
+}
+
+/* paginator of the job, ?page=N when none is set */
+func (s *Scraper) paginator() Paginator {
+	if s.Options.Pagination != nil {
+		if p, err := s.Options.Pagination.Paginator(); err == nil {
+			return p
+		}
+	}
+	return QueryParamPaginator{Start: 1}
+}
+
+/* start url with params set, the rest of the query kept */
+func withQuery(start *url.URL, params map[string]string) string {
+	u := *start
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,123 @@
+package scraper
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+/* urls of pages 1..n, stopping early when the paginator ends the listing */
+func pageURLs(p Paginator, start *url.URL, pages []*Page) []string {
+	var urls []string
+	var prev *Page
+	for n := 1; n <= len(pages); n++ {
+		next, ok := p.NextURL(start, n, prev)
+		if !ok {
+			break
+		}
+		urls = append(urls, next)
+		prev = pages[n-1]
+	}
+	return urls
+}
+
+func TestPaginators(t *testing.T) {
+	start, _ := url.Parse("https://shop.example/list?sort=new")
+	three := make([]*Page, 3)
+	tests := []struct {
+		name string
+		p    Paginator
+		want []string
+	}{
+		{"query default", QueryParamPaginator{Start: 1}, []string{
+			"https://shop.example/list?page=1&sort=new",
+			"https://shop.example/list?page=2&sort=new",
+			"https://shop.example/list?page=3&sort=new",
+		}},
+		{"query param and step", QueryParamPaginator{Param: "p", Start: 0, Step: 10}, []string{
+			"https://shop.example/list?p=0&sort=new",
+			"https://shop.example/list?p=10&sort=new",
+			"https://shop.example/list?p=20&sort=new",
+		}},
+		{"offset", OffsetPaginator{Limit: 20}, []string{
+			"https://shop.example/list?limit=20&offset=0&sort=new",
+			"https://shop.example/list?limit=20&offset=20&sort=new",
+			"https://shop.example/list?limit=20&offset=40&sort=new",
+		}},
+		{"offset without limit", OffsetPaginator{}, nil},
+		{"template page", TemplatePaginator{Template: "/list/page/{page}", Start: 1}, []string{
+			"https://shop.example/list/page/1",
+			"https://shop.example/list/page/2",
+			"https://shop.example/list/page/3",
+		}},
+		{"template offset", TemplatePaginator{Template: "?from={offset}", PageSize: 50}, []string{
+			"https://shop.example/list?from=0",
+			"https://shop.example/list?from=50",
+			"https://shop.example/list?from=100",
+		}},
+	}
+	for _, tt := range tests {
+		if got := pageURLs(tt.p, start, three); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNextLinkPaginator(t *testing.T) {
+	start, _ := url.Parse("https://shop.example/list")
+	pages := []*Page{
+		{URL: "https://shop.example/list", Body: []byte(`<a href="/list?p=2#top" rel="next">next</a>`)},
+		{URL: "https://shop.example/list?p=2", Body: []byte(`<link rel="prev next" href="?p=3">`)},
+		{URL: "https://shop.example/list?p=3", Body: []byte(`<a href="/list?p=2" rel="prev">back</a>`)},
+		{},
+	}
+	want := []string{
+		"https://shop.example/list",
+		"https://shop.example/list?p=2",
+		"https://shop.example/list?p=3",
+	}
+	p := NextLinkPaginator{}
+	if got := pageURLs(p, start, pages); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !p.Sequential() {
+		t.Error("next link paginator isn't sequential")
+	}
+
+	custom := NextLinkPaginator{Selector: "a.more"}
+	next, ok := custom.NextURL(start, 2, &Page{URL: start.String(), Body: []byte(`<a class="more" href="/list/2">more</a>`)})
+	if !ok || next != "https://shop.example/list/2" {
+		t.Errorf("custom selector: %s, %v", next, ok)
+	}
+}
+
+func TestPaginationConfig(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name   string
+		config PaginationConfig
+		want   Paginator
+		fails  bool
+	}{
+		{"default", PaginationConfig{}, QueryParamPaginator{Start: 1}, false},
+		{"query from 0", PaginationConfig{Type: "query", Param: "p", Start: &zero, Step: 2}, QueryParamPaginator{Param: "p", Start: 0, Step: 2}, false},
+		{"offset", PaginationConfig{Type: "offset", Limit: 25}, OffsetPaginator{Limit: 25}, false},
+		{"offset without limit", PaginationConfig{Type: "offset"}, nil, true},
+		{"template", PaginationConfig{Type: "template", Template: "/p/{page}"}, TemplatePaginator{Template: "/p/{page}", Start: 1}, false},
+		{"template without placeholder", PaginationConfig{Type: "template", Template: "/p/"}, nil, true},
+		{"template offset without page size", PaginationConfig{Type: "template", Template: "?o={offset}"}, nil, true},
+		{"next", PaginationConfig{Type: "next", Selector: "a.next"}, NextLinkPaginator{Selector: "a.next"}, false},
+		{"next with bad selector", PaginationConfig{Type: "next", Selector: "a[["}, nil, true},
+		{"unknown", PaginationConfig{Type: "infinite"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.config.Paginator()
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: err %v, want failure %v", tt.name, err, tt.fails)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
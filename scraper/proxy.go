@@ -0,0 +1,154 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyRoundRobin = "round-robin"
+	ProxySticky     = "sticky"
+)
+
+/* upstream proxies (http, https, socks5) with health tracking */
+type ProxyPool struct {
+	mu          sync.Mutex
+	proxies     []*proxyEntry
+	mode        string
+	next        int
+	sticky      map[string]*proxyEntry
+	MaxFailures int           // consecutive failures before a proxy is benched
+	BenchFor    time.Duration // how long a benched proxy is skipped
+}
+
+type proxyEntry struct {
+	url          *url.URL
+	failures     int
+	benchedUntil time.Time
+}
+
+type proxyKey struct{}
+
+func NewProxyPool(rawURLs []string, mode string) (*ProxyPool, error) {
+	if mode == "" {
+		mode = ProxyRoundRobin
+	}
+	if mode != ProxyRoundRobin && mode != ProxySticky {
+		return nil, fmt.Errorf("proxy: unknown mode %q", mode)
+	}
+
+	p := &ProxyPool{
+		mode:        mode,
+		sticky:      make(map[string]*proxyEntry),
+		MaxFailures: 3,
+		BenchFor:    5 * time.Minute,
+	}
+	for _, raw := range rawURLs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", raw, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy %q: unsupported scheme %q", u.Redacted(), u.Scheme)
+		}
+		p.proxies = append(p.proxies, &proxyEntry{url: u})
+	}
+	if len(p.proxies) == 0 {
+		return nil, errors.New("proxy: no proxies configured")
+	}
+	return p, nil
+}
+
+/* proxy for a request to host */
+func (p *ProxyPool) pick(host string) (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	if p.mode == ProxySticky {
+		if e, ok := p.sticky[host]; ok && !e.benchedUntil.After(now) {
+			return e.url, nil
+		}
+	}
+
+	for i := 0; i < len(p.proxies); i++ {
+		e := p.proxies[p.next%len(p.proxies)]
+		p.next++
+		if e.benchedUntil.After(now) {
+			continue
+		}
+		if p.mode == ProxySticky {
+			p.sticky[host] = e
+		}
+		return e.url, nil
+	}
+	return nil, errors.New("proxy: all proxies benched")
+}
+
+/* record the outcome of a request through proxy */
+func (p *ProxyPool) Report(proxy *url.URL, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.proxies {
+		if e.url != proxy {
+			continue
+		}
+		if err == nil {
+			e.failures = 0
+			return
+		}
+		e.failures++
+		if e.failures >= p.MaxFailures {
+			e.benchedUntil = time.Now().Add(p.BenchFor)
+			e.failures = 0
+		}
+		return
+	}
+}
+
+/* Transport.Proxy, the proxy is chosen per request in Scraper.do */
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	if u, ok := req.Context().Value(proxyKey{}).(*url.URL); ok {
+		return u, nil
+	}
+	return nil, nil
+}
+
+/* send a request through the proxy pool, returns the proxy used */
+func (s *Scraper) do(req *http.Request) (*http.Response, string, error) {
+	if s.Proxies == nil {
+		resp, err := s.client.Do(req)
+		return resp, "", err
+	}
+
+	proxy, err := s.Proxies.pick(req.URL.Hostname())
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy))
+
+	resp, err := s.client.Do(req)
+	if err != nil && req.Context().Err() == nil {
+		s.Proxies.Report(proxy, err)
+	} else if err == nil {
+		// a 407 is the proxy rejecting us, not the target site
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			s.Proxies.Report(proxy, errors.New(resp.Status))
+		} else {
+			s.Proxies.Report(proxy, nil)
+		}
+	}
+	return resp, proxy.Redacted(), err
+}
@@ -0,0 +1,201 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+/* forward proxy that counts the requests it relays */
+func forwardProxy(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	hits := &atomic.Int64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			http.Error(w, "not a proxy request", http.StatusBadRequest)
+			return
+		}
+		hits.Add(1)
+		out, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out.Header = r.Header.Clone()
+		out.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		maps.Copy(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hits
+}
+
+/* proxy url nothing listens on */
+func deadProxy() string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+func originServer(t *testing.T) *url.URL {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, "<html><title>origin</title></html>")
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL + "/page")
+	return u
+}
+
+/* scraper going through pool without delays, retries or robots.txt */
+func proxyScraper(pool *ProxyPool) *Scraper {
+	s := NewScraper(1, 5, "proxy-test", stubDB())
+	s.RespectRobots = false
+	s.Limiter = NewHostLimiter(RateLimit{})
+	s.Retry = RetryPolicy{MaxAttempts: 1}
+	s.Proxies = pool
+	return s
+}
+
+func newProxyPool(t *testing.T, mode string, urls ...string) *ProxyPool {
+	pool, err := NewProxyPool(urls, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	target := originServer(t)
+	p1, hits1 := forwardProxy(t)
+	p2, hits2 := forwardProxy(t)
+	s := proxyScraper(newProxyPool(t, ProxyRoundRobin, p1.URL, p2.URL))
+
+	var used []string
+	for range 4 {
+		res, err := s.fetch(context.Background(), target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", res.StatusCode)
+		}
+		used = append(used, res.Proxy)
+	}
+	if hits1.Load() != 2 || hits2.Load() != 2 {
+		t.Fatalf("proxy hits %d and %d, want 2 each", hits1.Load(), hits2.Load())
+	}
+	want := []string{p1.URL, p2.URL, p1.URL, p2.URL}
+	for i := range want {
+		if used[i] != want[i] {
+			t.Fatalf("proxies used %v, want %v", used, want)
+		}
+	}
+}
+
+func TestProxySticky(t *testing.T) {
+	target := originServer(t)
+	p1, hits1 := forwardProxy(t)
+	p2, hits2 := forwardProxy(t)
+	s := proxyScraper(newProxyPool(t, ProxySticky, p1.URL, p2.URL))
+
+	for range 3 {
+		if _, err := s.fetch(context.Background(), target, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits1.Load() != 3 || hits2.Load() != 0 {
+		t.Fatalf("proxy hits %d and %d, want all on the first", hits1.Load(), hits2.Load())
+	}
+}
+
+func TestProxyBenchedAfterMaxFailures(t *testing.T) {
+	target := originServer(t)
+	dead := deadProxy()
+	good, hits := forwardProxy(t)
+	pool := newProxyPool(t, ProxyRoundRobin, dead, good.URL)
+	pool.MaxFailures = 2
+	s := proxyScraper(pool)
+
+	// dead, good, dead again: benched after its second failure
+	var failed int
+	for range 3 {
+		if _, err := s.fetch(context.Background(), target, nil); err != nil {
+			failed++
+		}
+	}
+	if failed != 2 || hits.Load() != 1 {
+		t.Fatalf("%d failed and %d through the good proxy, want 2 and 1", failed, hits.Load())
+	}
+
+	for range 3 {
+		res, err := s.fetch(context.Background(), target, nil)
+		if err != nil {
+			t.Fatalf("benched proxy still used: %v", err)
+		}
+		if res.Proxy != good.URL {
+			t.Fatalf("proxy %s, want %s", res.Proxy, good.URL)
+		}
+	}
+	if hits.Load() != 4 {
+		t.Fatalf("%d requests through the good proxy, want 4", hits.Load())
+	}
+}
+
+func TestProxyAllBenched(t *testing.T) {
+	target := originServer(t)
+	pool := newProxyPool(t, ProxyRoundRobin, deadProxy())
+	pool.MaxFailures = 1
+	s := proxyScraper(pool)
+
+	if _, err := s.fetch(context.Background(), target, nil); err == nil {
+		t.Fatal("fetch through a dead proxy succeeded")
+	}
+	_, err := s.fetch(context.Background(), target, nil)
+	if err == nil || !strings.Contains(err.Error(), "all proxies benched") {
+		t.Fatalf("err %v, want all proxies benched", err)
+	}
+}
+
+func TestProxyStoredRedacted(t *testing.T) {
+	target := originServer(t)
+	p, hits := forwardProxy(t)
+	withAuth := strings.Replace(p.URL, "http://", "http://user:secret@", 1)
+	s := proxyScraper(newProxyPool(t, ProxyRoundRobin, withAuth))
+	want := strings.Replace(p.URL, "http://", "http://user:xxxxx@", 1)
+
+	if _, err := s.fetchAndStore(context.Background(), target.String(), 1, 0, sql.NullTime{}); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("%d requests through the proxy, want 1", hits.Load())
+	}
+
+	raw := stubExecsLike("INSERT INTO raw_html")
+	if len(raw) != 1 {
+		t.Fatalf("%d raw_html inserts, want 1", len(raw))
+	}
+	if proxy := raw[0].Args[14]; proxy != want {
+		t.Fatalf("raw_html.proxy %v, want %s", proxy, want)
+	}
+	attempts := stubExecsLike("INSERT INTO fetch_attempts")
+	if len(attempts) != 1 {
+		t.Fatalf("%d fetch_attempts inserts, want 1", len(attempts))
+	}
+	if proxy := attempts[0].Args[6]; proxy != want {
+		t.Fatalf("fetch_attempts.proxy %v, want %s", proxy, want)
+	}
+}
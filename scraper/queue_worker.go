@@ -0,0 +1,203 @@
+package scraper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"webScraper/database"
+)
+
+/* queue task kinds */
+const (
+	TaskBulkJob   = "bulk_job"
+	TaskResumeJob = "resume_job"
+)
+
+/* payload of TaskResumeJob */
+type resumeTask struct {
+	JobID int `json:"job_id"`
+}
+
+/* runs one kind of queue task; ErrBacklogFull hands the task back for later */
+type TaskFunc func(ctx context.Context, t *database.QueueTask) error
+
+/* pulls tasks from the queue and runs them, any number of workers may share a queue */
+type Worker struct {
+	Queue    *database.Queue
+	Name     string // identifies the worker's leases
+	Slots    int    // tasks run at once
+	Poll     time.Duration
+	Busy     time.Duration // before a task this worker had no room for is claimed again
+	db       *sql.DB
+	handlers map[string]TaskFunc
+}
+
+/* worker for the scraper's queue, it already runs TaskResumeJob */
+func (s *Scraper) NewWorker(name string, slots int) *Worker {
+	w := &Worker{
+		Queue:    s.Queue,
+		Name:     name,
+		Slots:    max(slots, 1),
+		Poll:     time.Second,
+		Busy:     10 * time.Second,
+		db:       s.DB,
+		handlers: make(map[string]TaskFunc),
+	}
+	w.Handle(TaskResumeJob, func(ctx context.Context, t *database.QueueTask) error {
+		var p resumeTask
+		if err := json.Unmarshal(t.Payload, &p); err != nil {
+			return err
+		}
+		return s.runJobCrawls(ctx, p.JobID)
+	})
+	return w
+}
+
+func (w *Worker) Handle(kind string, fn TaskFunc) {
+	w.handlers[kind] = fn
+}
+
+/* claim and run tasks until ctx ends, tasks still running then are handed back */
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for slot := 0; slot < w.Slots; slot++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				w.expireLeases()
+				t, err := w.Queue.Claim(w.Name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "worker %s: claim: %v\n", w.Name, err)
+				}
+				if t == nil || !w.process(ctx, t) {
+					select {
+					case <-time.After(w.Poll):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+/* run a claimed task and settle it with the queue, false when it was handed back */
+func (w *Worker) process(ctx context.Context, t *database.QueueTask) bool {
+	fn, ok := w.handlers[t.Kind]
+	if !ok {
+		w.fail(t, fmt.Sprintf("unknown task kind %q", t.Kind))
+		return true
+	}
+
+	// keep the lease and follow pauses and cancels made through the API
+	taskCtx, cancelTask := context.WithCancelCause(ctx)
+	defer cancelTask(nil)
+	beatCtx, stopBeat := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(max(w.Queue.Lease/3, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := w.Queue.Heartbeat(t, w.Name)
+				if errors.Is(err, database.ErrLeaseLost) {
+					// another worker has the task now, don't run it twice
+					fmt.Fprintf(os.Stderr, "worker %s: task %d: %v\n", w.Name, t.ID, err)
+					cancelTask(err)
+					return
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "worker %s: task %d: %v\n", w.Name, t.ID, err)
+				}
+				if t.JobID.Valid {
+					SyncJob(w.db, int(t.JobID.Int64))
+				}
+			case <-beatCtx.Done():
+				return
+			}
+		}
+	}()
+	err := fn(taskCtx, t)
+	stopBeat()
+
+	switch {
+	case errors.Is(context.Cause(taskCtx), database.ErrLeaseLost):
+		return true
+	case errors.Is(err, ErrBacklogFull):
+		w.release(t, w.Busy)
+		return false
+	case ctx.Err() != nil:
+		// shutdown, another worker takes over
+		w.release(t, 0)
+		return false
+	case err != nil:
+		w.fail(t, err.Error())
+		return true
+	}
+
+	if t.JobID.Valid {
+		if job, err := LoadJob(w.db, int(t.JobID.Int64)); err == nil && job.State == JobFailed {
+			w.fail(t, job.Error)
+			return true
+		}
+	}
+	if err := w.Queue.Ack(t, w.Name); err != nil {
+		fmt.Fprintf(os.Stderr, "worker %s: ack task %d: %v\n", w.Name, t.ID, err)
+	}
+	return true
+}
+
+/* fail the jobs whose workers kept crashing on them */
+func (w *Worker) expireLeases() {
+	jobIDs, err := w.Queue.ExpireLeases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "worker %s: expire leases: %v\n", w.Name, err)
+	}
+	for _, id := range jobIDs {
+		SetJobState(w.db, int(id), JobFailed, "worker lost, out of attempts")
+	}
+}
+
+/* retry the task later with its job queued again, or dead-letter it */
+func (w *Worker) fail(t *database.QueueTask, errMsg string) {
+	if t.JobID.Valid && t.Attempts < t.MaxAttempts {
+		w.requeueJob(t)
+	}
+	dead, err := w.Queue.Nack(t, w.Name, errMsg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "worker %s: nack task %d: %v\n", w.Name, t.ID, err)
+		return
+	}
+	if dead {
+		fmt.Fprintf(os.Stderr, "worker %s: task %d dead after %d attempts: %s\n", w.Name, t.ID, t.Attempts, errMsg)
+		if t.JobID.Valid {
+			SetJobState(w.db, int(t.JobID.Int64), JobFailed, errMsg)
+		}
+	}
+}
+
+func (w *Worker) release(t *database.QueueTask, delay time.Duration) {
+	if t.JobID.Valid {
+		w.requeueJob(t)
+	}
+	if err := w.Queue.Release(t, w.Name, delay); err != nil {
+		fmt.Fprintf(os.Stderr, "worker %s: release task %d: %v\n", w.Name, t.ID, err)
+	}
+}
+
+/* a failed job runs again on the next claim */
+func (w *Worker) requeueJob(t *database.QueueTask) {
+	id := int(t.JobID.Int64)
+	if state, err := JobState(w.db, id); err == nil && state == JobFailed {
+		if err := SetJobState(w.db, id, JobQueued, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "worker %s: job %d: %v\n", w.Name, id, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* politeness settings for a host */
+type RateLimit struct {
+	MinDelay time.Duration // gap between two requests
+	Burst    int           // requests allowed back to back
+}
+
+/* per host token bucket, hosts don't block each other */
+type HostLimiter struct {
+	mu          sync.Mutex
+	def         RateLimit
+	domains     map[string]RateLimit
+	crawlDelays map[string]time.Duration
+	hosts       map[string]time.Time
+}
+
+/* "delay" or "delay:burst", e.g. 2s or 500ms:3 */
+func ParseRateLimit(v string) (RateLimit, error) {
+	delay, burst, hasBurst := strings.Cut(strings.TrimSpace(v), ":")
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid delay", v)
+	}
+	rl := RateLimit{MinDelay: d, Burst: 1}
+	if hasBurst {
+		if rl.Burst, err = strconv.Atoi(burst); err != nil || rl.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("rate limit %q: invalid burst", v)
+		}
+	}
+	return rl, nil
+}
+
+func NewHostLimiter(def RateLimit) *HostLimiter {
+	return &HostLimiter{
+		def:         def,
+		domains:     make(map[string]RateLimit),
+		crawlDelays: make(map[string]time.Duration),
+		hosts:       make(map[string]time.Time),
+	}
+}
+
+/* limit for all hosts without a domain setting */
+func (l *HostLimiter) SetDefault(rl RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def = rl
+}
+
+/* limit for a domain and its subdomains */
+func (l *HostLimiter) SetDomain(domain string, rl RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.domains[strings.ToLower(domain)] = rl
+}
+
+/* crawl-delay from robots.txt, only ever slows a host down */
+func (l *HostLimiter) SetCrawlDelay(host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	host = strings.ToLower(host)
+	if d <= 0 {
+		delete(l.crawlDelays, host)
+		return
+	}
+	l.crawlDelays[host] = d
+}
+
+/* effective limit, caller holds mu */
+func (l *HostLimiter) limitFor(host string) RateLimit {
+	rl := l.def
+	best := ""
+	for domain, dl := range l.domains {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(best) {
+			best, rl = domain, dl
+		}
+	}
+	if d, ok := l.crawlDelays[host]; ok && d > rl.MinDelay {
+		rl = RateLimit{MinDelay: d, Burst: 1}
+	}
+	if rl.Burst < 1 {
+		rl.Burst = 1
+	}
+	return rl
+}
+
+/* block until the host may be requested again */
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	rl := l.limitFor(host)
+	now := time.Now()
+	// theoretical arrival time of the next request (GCRA)
+	tat := l.hosts[host]
+	if tat.Before(now) {
+		tat = now
+	}
+	allowAt := tat.Add(-time.Duration(rl.Burst-1) * rl.MinDelay)
+	l.hosts[host] = tat.Add(rl.MinDelay)
+	l.mu.Unlock()
+
+	wait := time.Until(allowAt)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
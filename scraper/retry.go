@@ -0,0 +1,83 @@
+package scraper
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* retry settings for fetchPage */
+type RetryPolicy struct {
+	MaxAttempts     int
+	BaseDelay       time.Duration // backoff before the 2nd attempt, doubled after
+	MaxDelay        time.Duration // cap for backoff and Retry-After
+	Jitter          float64       // share of the backoff that is randomized, 0..1
+	RetryableStatus []int
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       1 * time.Second,
+		MaxDelay:        30 * time.Second,
+		Jitter:          0.5,
+		RetryableStatus: []int{408, 425, 429, 500, 502, 503, 504},
+	}
+}
+
+/* outcome of a single request attempt */
+type Attempt struct {
+	Number     int
+	StatusCode int
+	Err        string
+	Duration   time.Duration
+	Wait       time.Duration // backoff before the next attempt
+	Proxy      string
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	return slices.Contains(p.RetryableStatus, code)
+}
+
+/* backoff after attempt n (1-based), false if Retry-After is beyond MaxDelay */
+func (p RetryPolicy) backoff(n int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if p.MaxDelay > 0 && d > p.MaxDelay {
+				return d, false
+			}
+			return d, true
+		}
+	}
+
+	d := p.BaseDelay << (n - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		j := min(p.Jitter, 1)
+		d = time.Duration(float64(d) * (1 - j + j*rand.Float64()))
+	}
+	return d, true
+}
+
+/* Retry-After as seconds or http date */
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
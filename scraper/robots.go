@@ -0,0 +1,328 @@
+package scraper
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsMaxBytes = 500 * 1024
+	robotsTTL      = 24 * time.Hour
+	robotsErrorTTL = 10 * time.Minute
+)
+
+/* parsed robots.txt of one host */
+type RobotsRules struct {
+	groups   []robotsGroup
+	Sitemaps []string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+/* allow everything, used for 4xx robots.txt */
+func allowAllRobots() *RobotsRules {
+	return &RobotsRules{}
+}
+
+/* disallow everything, used when robots.txt is unreachable */
+func disallowAllRobots() *RobotsRules {
+	return &RobotsRules{
+		groups: []robotsGroup{{
+			agents: []string{"*"},
+			rules:  []robotsRule{{allow: false, pattern: "/"}},
+		}},
+	}
+}
+
+/* parse robots.txt (RFC 9309 groups, allow/disallow, crawl-delay, sitemap) */
+func ParseRobots(r io.Reader) *RobotsRules {
+	rules := &RobotsRules{}
+	var current *robotsGroup
+	inAgentLines := false
+
+	sc := bufio.NewScanner(io.LimitReader(r, robotsMaxBytes))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgentLines {
+				rules.groups = append(rules.groups, robotsGroup{})
+				current = &rules.groups[len(rules.groups)-1]
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgentLines = true
+		case "allow", "disallow":
+			inAgentLines = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgentLines = false
+			if current == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs >= 0 {
+				current.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		case "sitemap":
+			// sitemap lines are global and don't end a group
+			if value != "" {
+				rules.Sitemaps = append(rules.Sitemaps, value)
+			}
+		}
+	}
+	return rules
+}
+
+/* merged rules of the most specific group matching the user agent */
+func (r *RobotsRules) groupFor(userAgent string) robotsGroup {
+	ua := strings.ToLower(userAgent)
+	best := ""
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			if a != "*" && strings.Contains(ua, a) && len(a) > len(best) {
+				best = a
+			}
+		}
+	}
+	if best == "" {
+		best = "*"
+	}
+
+	var merged robotsGroup
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			if a == best {
+				merged.rules = append(merged.rules, g.rules...)
+				if g.crawlDelay > merged.crawlDelay {
+					merged.crawlDelay = g.crawlDelay
+				}
+				break
+			}
+		}
+	}
+	return merged
+}
+
+/* check a path (incl. query) for the user agent, returns the deciding rule */
+func (r *RobotsRules) Check(userAgent, path string) (bool, string) {
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true, ""
+	}
+
+	allowed, matched := true, ""
+	longest := -1
+	for _, rule := range r.groupFor(userAgent).rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		// longest match wins, allow wins a tie
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			longest = len(rule.pattern)
+			allowed = rule.allow
+			matched = rule.pattern
+		}
+	}
+	return allowed, matched
+}
+
+/* crawl-delay of the group matching the user agent */
+func (r *RobotsRules) CrawlDelay(userAgent string) time.Duration {
+	return r.groupFor(userAgent).crawlDelay
+}
+
+/* match robots path pattern with '*' wildcards and '$' end anchor */
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if anchored && i == len(parts)-1 {
+			return strings.HasSuffix(path[pos:], parts[i])
+		}
+		idx := strings.Index(path[pos:], parts[i])
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(parts[i])
+	}
+	return !anchored || pos == len(path)
+}
+
+/* per host robots.txt cache */
+type robotsCache struct {
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	rules   *RobotsRules
+	expires time.Time
+	ready   chan struct{}
+}
+
+func newRobotsCache() *robotsCache {
+	return &robotsCache{entries: make(map[string]*robotsEntry)}
+}
+
+/* cached rules for scheme://host, fetched once per TTL */
+func (c *robotsCache) get(ctx context.Context, do func(*http.Request) (*http.Response, error), userAgent string, u *url.URL) (*RobotsRules, error) {
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		e = &robotsEntry{ready: make(chan struct{})}
+		c.entries[key] = e
+		c.mu.Unlock()
+
+		e.rules, e.expires = fetchRobots(ctx, do, userAgent, key)
+		if err := ctx.Err(); err != nil {
+			// don't cache a result of our own cancellation
+			e.expires = time.Time{}
+			close(e.ready)
+			return nil, err
+		}
+		close(e.ready)
+		return e.rules, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		if e.expires.IsZero() {
+			// the fetching caller was canceled, try again ourselves
+			return c.get(ctx, do, userAgent, u)
+		}
+		return e.rules, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/* marks the requests of a robots.txt fetch, their redirects aren't checked against robots.txt */
+type robotsFetchKey struct{}
+
+/* http get robots.txt && status handling */
+func fetchRobots(ctx context.Context, do func(*http.Request) (*http.Response, error), userAgent, origin string) (*RobotsRules, time.Time) {
+	ctx = context.WithValue(ctx, robotsFetchKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return disallowAllRobots(), time.Now().Add(robotsErrorTTL)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := do(req)
+	if err != nil {
+		return disallowAllRobots(), time.Now().Add(robotsErrorTTL)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return ParseRobots(resp.Body), time.Now().Add(robotsTTL)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// no robots.txt means no restrictions
+		return allowAllRobots(), time.Now().Add(robotsTTL)
+	default:
+		return disallowAllRobots(), time.Now().Add(robotsErrorTTL)
+	}
+}
+
+/* robots.txt of the url's host */
+func (s *Scraper) Robots(ctx context.Context, u *url.URL) (*RobotsRules, error) {
+	do := func(req *http.Request) (*http.Response, error) {
+		resp, _, err := s.do(req)
+		return resp, err
+	}
+	return s.robots.get(ctx, do, s.UserAgent, u)
+}
+
+/* robots check before fetching, returns the skip reason or the ctx error */
+func (s *Scraper) robotsAllowed(ctx context.Context, u *url.URL) (bool, string, error) {
+	if !s.RespectRobots {
+		return true, "", nil
+	}
+	rules, err := s.Robots(ctx, u)
+	if err != nil {
+		return false, "", err
+	}
+	s.Limiter.SetCrawlDelay(u.Hostname(), rules.CrawlDelay(s.UserAgent))
+
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allowed, rule := rules.Check(s.UserAgent, path)
+	if !allowed {
+		return false, fmt.Sprintf("disallowed by robots.txt (rule %q)", rule), nil
+	}
+	return true, "", nil
+}
+
+/* robots check of every redirect hop, with net/http's limit of 10 redirects */
+func (s *Scraper) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.Context().Value(robotsFetchKey{}) != nil {
+		// waiting on the robots.txt being fetched would never end
+		return nil
+	}
+	ok, reason, err := s.robotsAllowed(req.Context(), req.URL)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return skipped("redirect to %s %s", req.URL, reason)
+	}
+	return nil
+}
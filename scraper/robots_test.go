@@ -0,0 +1,200 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobotsTxt = `Disallow: /before-any-group
+
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /secret # not part of the rule
+Disallow: /tie
+Allow: /tie
+Crawl-delay: 2
+
+User-agent: GoodBot
+User-agent: otherbot
+Disallow: /nogood
+Crawl-delay: 0.5
+
+user-agent: goodbot
+ALLOW: /
+Disallow: /tmp
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: goodbot-news
+Disallow: /
+
+User-agent: emptybot
+Disallow:
+`
+
+func TestParseRobots(t *testing.T) {
+	rules := ParseRobots(strings.NewReader(testRobotsTxt))
+	if want := []string{"https://example.com/sitemap.xml"}; !reflect.DeepEqual(rules.Sitemaps, want) {
+		t.Errorf("sitemaps %v, want %v", rules.Sitemaps, want)
+	}
+	var agents [][]string
+	for _, g := range rules.groups {
+		agents = append(agents, g.agents)
+	}
+	want := [][]string{{"*"}, {"goodbot", "otherbot"}, {"goodbot"}, {"goodbot-news"}, {"emptybot"}}
+	if !reflect.DeepEqual(agents, want) {
+		t.Errorf("groups %v, want %v", agents, want)
+	}
+}
+
+func TestRobotsGroupFor(t *testing.T) {
+	rules := ParseRobots(strings.NewReader(testRobotsTxt))
+	tests := []struct {
+		userAgent  string
+		rules      int
+		crawlDelay time.Duration
+	}{
+		{"SomeBot/1.0", 6, 2 * time.Second},
+		{"Mozilla/5.0 (compatible; GoodBot/1.0)", 3, 500 * time.Millisecond},
+		{"GoodBot-News/2.0", 1, 0},
+		{"otherbot", 1, 500 * time.Millisecond},
+		{"EmptyBot", 0, 0},
+	}
+	for _, tt := range tests {
+		g := rules.groupFor(tt.userAgent)
+		if len(g.rules) != tt.rules || g.crawlDelay != tt.crawlDelay {
+			t.Errorf("%s: %d rules, crawl-delay %v; want %d, %v", tt.userAgent, len(g.rules), g.crawlDelay, tt.rules, tt.crawlDelay)
+		}
+		if d := rules.CrawlDelay(tt.userAgent); d != tt.crawlDelay {
+			t.Errorf("%s: CrawlDelay %v, want %v", tt.userAgent, d, tt.crawlDelay)
+		}
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/", "/x", true},
+		{"/private", "/private/x", true},
+		{"/private", "/priv", false},
+		{"/*.php", "/a/b.php", true},
+		{"/*.php", "/a/b.php?x=1", true},
+		{"/*.php", "/a/b.html", false},
+		{"/*.php$", "/a.php", true},
+		{"/*.php$", "/a.php?x=1", false},
+		{"/a$", "/a", true},
+		{"/a$", "/ab", false},
+		{"/a*b*c", "/axxbyyc", true},
+		{"/a*b*c", "/axxc", false},
+		{"*", "/anything", true},
+		{"/*/edit$", "/page/1/edit", true},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.match {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestRobotsCheck(t *testing.T) {
+	rules := ParseRobots(strings.NewReader(testRobotsTxt))
+	tests := []struct {
+		userAgent, path string
+		allowed         bool
+		rule            string
+	}{
+		{"SomeBot", "/", true, ""},
+		{"SomeBot", "", true, ""},
+		{"SomeBot", "/before-any-group", true, ""},
+		{"SomeBot", "/private/x", false, "/private"},
+		{"SomeBot", "/private/public/a", true, "/private/public"},
+		{"SomeBot", "/doc.pdf", false, "/*.pdf$"},
+		{"SomeBot", "/doc.pdf?x=1", true, ""},
+		{"SomeBot", "/secret/x", false, "/secret"},
+		{"SomeBot", "/tie", true, "/tie"},
+		{"SomeBot", "/robots.txt", true, ""},
+		{"GoodBot/1.0", "/nogood/x", false, "/nogood"},
+		{"GoodBot/1.0", "/tmp", false, "/tmp"},
+		{"GoodBot/1.0", "/private", true, "/"},
+		{"GoodBot-News/2.0", "/x", false, "/"},
+		{"otherbot", "/nogood", false, "/nogood"},
+		{"otherbot", "/private", true, ""},
+		{"EmptyBot", "/private", true, ""},
+	}
+	for _, tt := range tests {
+		allowed, rule := rules.Check(tt.userAgent, tt.path)
+		if allowed != tt.allowed || rule != tt.rule {
+			t.Errorf("%s %q: allowed %v by %q, want %v by %q", tt.userAgent, tt.path, allowed, rule, tt.allowed, tt.rule)
+		}
+	}
+}
+
+func TestRobotsDisallowAll(t *testing.T) {
+	if allowed, _ := disallowAllRobots().Check("any", "/x"); allowed {
+		t.Error("disallowAllRobots allows /x")
+	}
+	if allowed, _ := allowAllRobots().Check("any", "/x"); !allowed {
+		t.Error("allowAllRobots disallows /x")
+	}
+}
+
+/* scraper respecting robots.txt without politeness delays */
+func robotsScraper() *Scraper {
+	s := NewScraper(1, 5, "robots-test", stubDB())
+	s.Limiter = NewHostLimiter(RateLimit{})
+	return s
+}
+
+func TestRobotsTxtRedirectedOnSameOrigin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.Redirect(w, r, "/static/robots", http.StatusMovedPermanently)
+		case "/static/robots":
+			io.WriteString(w, "User-agent: *\nDisallow: /private\n")
+		}
+	}))
+	defer srv.Close()
+	s := robotsScraper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for path, want := range map[string]bool{"/page": true, "/private/x": false} {
+		u, _ := url.Parse(srv.URL + path)
+		allowed, reason, err := s.robotsAllowed(ctx, u)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if allowed != want {
+			t.Errorf("%s: allowed %v (%s), want %v", path, allowed, reason, want)
+		}
+	}
+}
+
+func TestRobotsCanceledIsNotAVerdict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "User-agent: *\nDisallow:\n")
+	}))
+	defer srv.Close()
+	s := robotsScraper()
+	u, _ := url.Parse(srv.URL + "/page")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := s.robotsAllowed(ctx, u); err != context.Canceled {
+		t.Fatalf("err %v, want context.Canceled", err)
+	}
+	if allowed, reason, err := s.robotsAllowed(context.Background(), u); err != nil || !allowed {
+		t.Fatalf("allowed %v (%s), err %v after a canceled check, want allowed", allowed, reason, err)
+	}
+}
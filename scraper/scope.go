@@ -0,0 +1,169 @@
+package scraper
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+/* which discovered links a depth crawl may follow */
+type Scope struct {
+	SameHost        bool     `json:"same_host"`
+	AllowSubdomains bool     `json:"allow_subdomains"` // any host of the start url's site
+	PathPrefix      string   `json:"path_prefix"`
+	Include         []string `json:"include"` // regexes on the full url
+	Exclude         []string `json:"exclude"`
+	IncludeGlobs    []string `json:"include_globs"` // globs on path and query, ** spans '/'
+	ExcludeGlobs    []string `json:"exclude_globs"`
+	MaxPerHost      int      `json:"max_per_host"`
+}
+
+/* scope when a job doesn't set one */
+func DefaultScope() Scope {
+	return Scope{SameHost: true}
+}
+
+/* compile patterns, reports invalid ones */
+func (sc Scope) Validate() error {
+	_, err := sc.compile(&url.URL{})
+	return err
+}
+
+type scopeMatcher struct {
+	scope   Scope
+	host    string
+	site    string
+	include []scopePattern
+	exclude []scopePattern
+	mu      sync.Mutex
+	perHost map[string]int
+}
+
+func (sc Scope) compile(start *url.URL) (*scopeMatcher, error) {
+	m := &scopeMatcher{
+		scope:   sc,
+		host:    strings.ToLower(start.Hostname()),
+		perHost: make(map[string]int),
+	}
+	m.site = m.host
+	if site, err := publicsuffix.EffectiveTLDPlusOne(m.host); err == nil {
+		m.site = site
+	}
+
+	for _, p := range sc.Include {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("scope include %q: %w", p, err)
+		}
+		m.include = append(m.include, scopePattern{re: re})
+	}
+	for _, g := range sc.IncludeGlobs {
+		m.include = append(m.include, scopePattern{re: globToRegexp(g), onPath: true})
+	}
+	for _, p := range sc.Exclude {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("scope exclude %q: %w", p, err)
+		}
+		m.exclude = append(m.exclude, scopePattern{re: re})
+	}
+	for _, g := range sc.ExcludeGlobs {
+		m.exclude = append(m.exclude, scopePattern{re: globToRegexp(g), onPath: true})
+	}
+	return m, nil
+}
+
+/* regexp on the full url, or a glob on path and query */
+type scopePattern struct {
+	re     *regexp.Regexp
+	onPath bool
+}
+
+func (p scopePattern) match(link, target string) bool {
+	if p.onPath {
+		return p.re.MatchString(target)
+	}
+	return p.re.MatchString(link)
+}
+
+/* glob to an anchored regexp on path+query */
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+/* check a canonical link, counts it against MaxPerHost when in scope */
+func (m *scopeMatcher) allows(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	switch {
+	case m.scope.AllowSubdomains:
+		if host != m.site && !strings.HasSuffix(host, "."+m.site) {
+			return false
+		}
+	case m.scope.SameHost:
+		if host != m.host {
+			return false
+		}
+	}
+	if m.scope.PathPrefix != "" && !strings.HasPrefix(u.Path, m.scope.PathPrefix) {
+		return false
+	}
+
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	for _, p := range m.exclude {
+		if p.match(link, target) {
+			return false
+		}
+	}
+	if len(m.include) > 0 {
+		included := false
+		for _, p := range m.include {
+			if p.match(link, target) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	if m.scope.MaxPerHost > 0 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.perHost[host] >= m.scope.MaxPerHost {
+			return false
+		}
+		m.perHost[host]++
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob, target string
+		match        bool
+	}{
+		{"/blog/*", "/blog/post", true},
+		{"/blog/*", "/blog/2024/post", false},
+		{"/blog/**", "/blog/2024/post", true},
+		{"/blog/**", "/blog", false},
+		{"/*.html", "/a.html", true},
+		{"/*.html", "/a.html?x=1", false},
+		{"/*.html*", "/a.html?x=1", true},
+		{"/page?", "/page1", true},
+		{"/page?", "/page/", false},
+		{"/a.b", "/axb", false},
+		{"/search?q=*", "/search?q=go", true},
+	}
+	for _, tt := range tests {
+		if got := globToRegexp(tt.glob).MatchString(tt.target); got != tt.match {
+			t.Errorf("glob %q on %q: %v, want %v", tt.glob, tt.target, got, tt.match)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	start, _ := url.Parse("https://www.example.com/docs/")
+	tests := []struct {
+		name  string
+		scope Scope
+		link  string
+		allow bool
+	}{
+		{"same host", DefaultScope(), "https://www.example.com/x", true},
+		{"other host", DefaultScope(), "https://api.example.com/x", false},
+		{"subdomain", Scope{AllowSubdomains: true}, "https://api.example.com/x", true},
+		{"site itself", Scope{AllowSubdomains: true}, "https://example.com/x", true},
+		{"other site", Scope{AllowSubdomains: true}, "https://notexample.com/x", false},
+		{"any host", Scope{}, "https://other.org/x", true},
+		{"path prefix", Scope{PathPrefix: "/docs/"}, "https://www.example.com/docs/a", true},
+		{"outside prefix", Scope{PathPrefix: "/docs/"}, "https://www.example.com/blog/a", false},
+		{"include regexp", Scope{Include: []string{`/docs/v\d+/`}}, "https://www.example.com/docs/v2/a", true},
+		{"not included", Scope{Include: []string{`/docs/v\d+/`}}, "https://www.example.com/docs/latest/a", false},
+		{"exclude regexp", Scope{Exclude: []string{`\.pdf$`}}, "https://www.example.com/a.pdf", false},
+		{"include glob", Scope{IncludeGlobs: []string{"/docs/**"}}, "https://www.example.com/docs/a/b", true},
+		{"exclude glob on query", Scope{ExcludeGlobs: []string{"**print=*"}}, "https://www.example.com/a?print=1", false},
+		{"exclude beats include", Scope{IncludeGlobs: []string{"/docs/**"}, ExcludeGlobs: []string{"/docs/old/**"}},
+			"https://www.example.com/docs/old/a", false},
+	}
+	for _, tt := range tests {
+		m, err := tt.scope.compile(start)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := m.allows(tt.link); got != tt.allow {
+			t.Errorf("%s: allows(%s) = %v, want %v", tt.name, tt.link, got, tt.allow)
+		}
+	}
+}
+
+func TestScopeMaxPerHost(t *testing.T) {
+	start, _ := url.Parse("https://example.com/")
+	m, err := Scope{MaxPerHost: 2}.compile(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, true, false} {
+		if got := m.allows("https://example.com/" + string(rune('a'+i))); got != want {
+			t.Errorf("link %d: %v, want %v", i+1, got, want)
+		}
+	}
+	if !m.allows("https://other.org/a") {
+		t.Error("other host counted against example.com")
+	}
+}
+
+func TestScopeValidate(t *testing.T) {
+	if err := (Scope{Include: []string{"("}}).Validate(); err == nil {
+		t.Error("invalid include regexp accepted")
+	}
+	if err := (Scope{Exclude: []string{"[a-"}}).Validate(); err == nil {
+		t.Error("invalid exclude regexp accepted")
+	}
+	if err := (Scope{IncludeGlobs: []string{"/(a"}}).Validate(); err != nil {
+		t.Errorf("glob with regexp meta characters: %v", err)
+	}
+}
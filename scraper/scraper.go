@@ -1,49 +1,80 @@
 package scraper
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
 	"time"
+
+	"webScraper/database"
+	"webScraper/parser"
 )
 
 type Scraper struct {
-	MaxConcurrency int
-	Timeout        int
-	UserAgent      string
-	DB             *sql.DB
-	MaxPages       int
-	TotalResults   int
-	CompletedAt    sql.NullTime
-	client         *http.Client
+	MaxConcurrency      int
+	Timeout             int
+	UserAgent           string
+	DB                  *sql.DB
+	MaxPages            int
+	TotalResults        int
+	CompletedAt         sql.NullTime
+	RespectRobots       bool
+	Limiter             *HostLimiter
+	Retry               RetryPolicy
+	Proxies             *ProxyPool
+	MaxBodyBytes        int64 // larger bodies are truncated, 0 means no cap
+	AllowedContentTypes []string
+	HeadCheck           bool // HEAD before GET to skip unwanted types early
+	Canonicalizer       Canonicalizer
+	Options             JobOptions
+	Pool                *Pool     // runs the page fetches of all jobs
+	Governor            *Governor // admits the scrapes and crawls of all jobs
+	Queue               *database.Queue
+	headers             http.Header
+	jar                 *SessionJar
+	auth                *authHeader
+	client              *http.Client
+	robots              *robotsCache
+	cacheStats          *CacheStats
+	job                 *jobTracker
 }
 
 /* config of a new scraper */
 func NewScraper(maxConcurrency, timeout int, userAgent string, db *sql.DB) *Scraper {
-	return &Scraper{
-		MaxConcurrency: maxConcurrency,
-		Timeout:        timeout,
-		UserAgent:      userAgent,
-		DB:             db,
+	s := &Scraper{
+		MaxConcurrency:      maxConcurrency,
+		Timeout:             timeout,
+		UserAgent:           userAgent,
+		DB:                  db,
+		RespectRobots:       true,
+		robots:              newRobotsCache(),
+		Limiter:             NewHostLimiter(RateLimit{MinDelay: 1 * time.Second, Burst: 1}),
+		Retry:               DefaultRetryPolicy(),
+		MaxBodyBytes:        10 << 20,
+		AllowedContentTypes: DefaultContentTypes(),
+		Canonicalizer:       DefaultCanonicalizer(),
+		cacheStats:          &CacheStats{},
+		Pool:                NewPool(maxConcurrency, 0),
+		Governor:            NewGovernor(10, 2, 1000),
+		Queue:               database.NewQueue(db),
 		client: &http.Client{
-			Timeout: time.Duration(timeout) * time.Second,
-			Transport: &http.Transport{
-				TLSHandshakeTimeout:   5 * time.Second,
-				ResponseHeaderTimeout: 10 * time.Second,
-				IdleConnTimeout:       90 * time.Second,
-				DisableKeepAlives:     true,
-			},
+			Timeout:   time.Duration(timeout) * time.Second,
+			Transport: NewTransport(DefaultTransportConfig()),
 		},
 	}
+	s.client.CheckRedirect = s.checkRedirect
+	return s
 }
 
 /* setting for scrape operation */
-func (s *Scraper) Scrape(ctx context.Context, url string, maxPages int) {
+func (s *Scraper) Scrape(ctx context.Context, startURL string, maxPages int) {
 	// Context-Überwachung hinzufügen
 	select {
 	case <-ctx.Done():
@@ -51,40 +82,40 @@ func (s *Scraper) Scrape(ctx context.Context, url string, maxPages int) {
 	default:
 	}
 
-	var wg sync.WaitGroup
-	concurrencyLimiter := make(chan struct{}, s.MaxConcurrency)
-
-	totalResults := 0
-	completedAt := sql.NullTime{Valid: false}
-
 	if maxPages == 1 {
-		s.fetchPage(ctx, url, maxPages, totalResults, completedAt)
+		s.doTask(ctx, Task{URL: startURL, MaxPages: maxPages})
+		return
+	}
+
+	start, err := url.Parse(startURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scrape: invalid url %q: %v\n", startURL, err)
+		return
+	}
+	p := s.paginator()
+	if p.Sequential() {
+		s.scrapeSequential(ctx, p, start, maxPages)
 		return
 	}
 
+	var wg sync.WaitGroup
 	for i := 1; i <= maxPages; i++ {
-		// Context-Check vor jeder neuen Seite
-		select {
-		case <-ctx.Done():
-			return
-		default:
+		pageURL, ok := p.NextURL(start, i, nil)
+		if !ok {
+			break
 		}
 
+		// blocks while the pool's queue is full
 		wg.Add(1)
-		concurrencyLimiter <- struct{}{}
-
-		pageURL := fmt.Sprintf("%s?page=%d", url, i)
-		go func(url string, page int) {
-			defer func() {
-				<-concurrencyLimiter
-				wg.Done()
-			}()
-
-			s.fetchPage(ctx, url, maxPages, totalResults, completedAt)
-
-			// Add delay between requests to be respectful to servers
-			time.Sleep(2 * time.Second)
-		}(pageURL, i)
+		err := s.Pool.Submit(ctx, s.task(Task{
+			URL:      pageURL,
+			MaxPages: maxPages,
+			Callback: func(Result) { wg.Done() },
+		}))
+		if err != nil {
+			wg.Done()
+			break
+		}
 	}
 
 	// Warten mit Context-Überwachung
@@ -103,56 +134,365 @@ func (s *Scraper) Scrape(ctx context.Context, url string, maxPages int) {
 	}
 }
 
-/* http get && error handling */
-func (s *Scraper) fetchPage(ctx context.Context, url string, maxPages, totalResults int, completedAt sql.NullTime) {
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		fmt.Fprintf(os.Stderr, "fetch: invalid url %q\n", url)
-		return
-	}
+/* pages that link to the next one, fetched in order until the listing ends */
+func (s *Scraper) scrapeSequential(ctx context.Context, p Paginator, start *url.URL, maxPages int) {
+	seen := make(map[string]bool)
+	var prev *Page
+	for i := 1; i <= maxPages && ctx.Err() == nil; i++ {
+		pageURL, ok := p.NextURL(start, i, prev)
+		if !ok || seen[pageURL] {
+			return
+		}
+		seen[pageURL] = true
 
-	// Add delay before making request to be respectful to servers
-	time.Sleep(1 * time.Second)
+		res := s.doTask(ctx, Task{URL: pageURL, MaxPages: maxPages})
+		if res.Error != nil {
+			return
+		}
+		prev = &Page{URL: res.FinalURL, StatusCode: res.StatusCode, Body: res.RawHTML, Encoding: res.Encoding}
+	}
+}
 
-	// Context mit zusätzlichem Timeout
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
-	defer cancel()
+/* http get && error handling, skips are returned as *SkipError */
+func (s *Scraper) fetchPage(ctx context.Context, pageURL string, maxPages, totalResults int, completedAt sql.NullTime) (*fetchResult, error) {
+	if err := idleWait(ctx, func() error { return s.job.wait(ctx) }); err != nil {
+		return nil, err
+	}
+	res, err := s.fetchAndStore(ctx, pageURL, maxPages, totalResults, completedAt)
+	if ctx.Err() == nil {
+		s.job.count(res, err)
+	}
+	return res, err
+}
 
-	req, _ := http.NewRequestWithContext(ctxWithTimeout, "GET", url, nil)
-	// user-Agent to avoid blocking
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36")
+/* robots check, cache and fetch, then store the page or why it was skipped */
+func (s *Scraper) fetchAndStore(ctx context.Context, pageURL string, maxPages, totalResults int, completedAt sql.NullTime) (*fetchResult, error) {
+	if !strings.HasPrefix(pageURL, "http://") && !strings.HasPrefix(pageURL, "https://") {
+		fmt.Fprintf(os.Stderr, "fetch: invalid url %q\n", pageURL)
+		return nil, fmt.Errorf("invalid url %q", pageURL)
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetch: invalid url %q: %v\n", pageURL, err)
+		return nil, err
+	}
 
-	resp, err := s.client.Do(req)
+	ok, reason, err := s.robotsAllowed(ctx, u)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fetch: %v\n", err)
-		return
+		return nil, err
+	}
+	if !ok {
+		s.saveSkippedURLToDB(pageURL, reason)
+		return nil, &SkipError{Reason: reason}
+	}
+
+	mode := s.cacheMode()
+	var entry *cacheEntry
+	if mode != CacheOff {
+		entry, _ = s.loadCacheEntry(pageURL)
+	}
+	if entry != nil && mode == CachePrefer && entry.fresh(time.Now()) {
+		res := &fetchResult{StatusCode: http.StatusOK, FinalURL: cmp.Or(entry.finalURL, pageURL), FromCache: true}
+		if s.loadStoredBody(entry, res) {
+			s.cacheStats.Hits.Add(1)
+			return res, nil
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	var validators http.Header
+	if entry != nil {
+		validators = entry.validators()
+	}
+	res, err := s.fetch(ctx, u, validators)
+	if err == nil && res.StatusCode == http.StatusNotModified {
+		if entry != nil && s.loadStoredBody(entry, res) {
+			s.saveAttemptsToDB(pageURL, res.Attempts)
+			s.cacheStats.Revalidated.Add(1)
+			res.NotModified = true
+			s.saveCacheEntry(pageURL, res)
+			return res, nil
+		}
+		// stored copy is gone, download it again
+		first := res.Attempts
+		res, err = s.fetch(ctx, u, nil)
+		res.Attempts = append(first, res.Attempts...)
+	}
+	s.saveAttemptsToDB(pageURL, res.Attempts)
+	if reason, ok := skipReason(err); ok {
+		s.saveSkippedURLToDB(pageURL, reason)
+		return nil, err
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "read body %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "fetch %s: %v\n", pageURL, err)
+		return nil, err
 	}
 
 	s.saveRawHTMLToDB(
-		url,
-		body,
+		pageURL,
+		res,
 		maxPages,
 		s.MaxConcurrency,
 		totalResults,
 		completedAt,
 	)
+	if mode != CacheOff {
+		res.CacheMiss = true
+		s.cacheStats.Misses.Add(1)
+		if res.StatusCode == http.StatusOK && !res.Truncated {
+			// after the body is stored, the entry points at it
+			s.saveCacheEntry(pageURL, res)
+		}
+	}
+	return res, nil
 }
 
-/* raw html db save */
-func (s *Scraper) saveRawHTMLToDB(url string, body []byte, maxPages, concurrency, totalResults int, completedAt sql.NullTime) {
-	_, err := s.DB.Exec(
+/* outcome of a page fetch */
+type fetchResult struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	Truncated    bool
+	Encoding     string
+	FinalURL     string
+	Redirects    []string
+	ResponseTime time.Duration
+	Proxy        string
+	Attempts     []Attempt
+	NotModified  bool // 304, Body is the stored copy
+	FromCache    bool // fresh per Cache-Control, not requested at all
+	CacheMiss    bool // cache used but downloaded in full
+}
+
+/* GET with retries, validators make it a conditional request */
+func (s *Scraper) fetch(ctx context.Context, u *url.URL, validators http.Header) (*fetchResult, error) {
+	res := &fetchResult{}
+	maxAttempts := max(s.Retry.MaxAttempts, 1)
+
+	if s.HeadCheck {
+		if err := s.waitHost(ctx, u.Hostname()); err != nil {
+			return res, err
+		}
+		release, err := s.hostSlot(ctx, u.Hostname())
+		if err != nil {
+			return res, err
+		}
+		err = s.headCheck(ctx, u)
+		release()
+		if err != nil {
+			return res, err
+		}
+	}
+
+	for n := 1; ; n++ {
+		// per host politeness delay
+		if err := s.waitHost(ctx, u.Hostname()); err != nil {
+			return res, err
+		}
+
+		// requests to the host from all jobs share MaxPerHost
+		release, err := s.hostSlot(ctx, u.Hostname())
+		if err != nil {
+			return res, err
+		}
+		attempt := Attempt{Number: n}
+		start := time.Now()
+		pr, err := s.doRequest(ctx, u, validators)
+		release()
+		resp := pr.resp
+		attempt.Duration = time.Since(start)
+		attempt.Proxy = pr.proxy
+		res.ResponseTime = attempt.Duration
+		res.Proxy = pr.proxy
+
+		retry := false
+		if resp != nil {
+			attempt.StatusCode = resp.StatusCode
+		}
+		_, skip := skipReason(err)
+		switch {
+		case err != nil:
+			attempt.Err = err.Error()
+			retry = ctx.Err() == nil && !skip
+		default:
+			retry = s.Retry.retryableStatus(resp.StatusCode)
+		}
+
+		if !retry || n >= maxAttempts {
+			res.Attempts = append(res.Attempts, attempt)
+			if err != nil {
+				return res, err
+			}
+			if retry {
+				return res, fmt.Errorf("giving up after %d attempts: status %d", n, resp.StatusCode)
+			}
+			res.StatusCode = resp.StatusCode
+			res.Header = resp.Header
+			res.Body = pr.body
+			res.Truncated = pr.truncated
+			res.Encoding = parser.DetectCharset(pr.body, resp.Header.Get("Content-Type"))
+			res.FinalURL = resp.Request.URL.String()
+			res.Redirects = redirectChain(resp)
+			return res, nil
+		}
+
+		wait, ok := s.Retry.backoff(n, resp)
+		attempt.Wait = wait
+		res.Attempts = append(res.Attempts, attempt)
+		if !ok {
+			return res, fmt.Errorf("giving up: Retry-After %s exceeds max delay", wait)
+		}
+
+		err = idleWait(ctx, func() error {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+}
+
+/* per host politeness delay, spent without a pool slot */
+func (s *Scraper) waitHost(ctx context.Context, host string) error {
+	return idleWait(ctx, func() error { return s.Limiter.Wait(ctx, host) })
+}
+
+/* response of a single GET */
+type pageResponse struct {
+	resp      *http.Response
+	body      []byte
+	truncated bool
+	proxy     string
+}
+
+/* single GET, body is checked and read up to MaxBodyBytes */
+func (s *Scraper) doRequest(ctx context.Context, u *url.URL, validators http.Header) (*pageResponse, error) {
+	pr := &pageResponse{}
+
+	// Context mit zusätzlichem Timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, s.requestTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctxWithTimeout, "GET", u.String(), nil)
+	if err != nil {
+		return pr, err
+	}
+	s.setRequestHeaders(req)
+	for k, v := range validators {
+		req.Header[k] = v
+	}
+
+	resp, proxy, err := s.do(req)
+	pr.resp, pr.proxy = resp, proxy
+	if err != nil {
+		return pr, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		// no body, the stored copy is still current
+		return pr, nil
+	}
+
+	pr.body, pr.truncated, err = s.readBody(resp)
+	if err != nil {
+		if _, ok := skipReason(err); ok {
+			return pr, err
+		}
+		return pr, fmt.Errorf("read body: %w", err)
+	}
+	return pr, nil
+}
+
+/* urls that redirected to the response, oldest first */
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request; r.Response != nil; r = r.Response.Request {
+		chain = append([]string{r.Response.Request.URL.String()}, chain...)
+	}
+	return chain
+}
+
+/* raw html db save, the body goes to html_blobs once per content hash */
+func (s *Scraper) saveRawHTMLToDB(url string, res *fetchResult, maxPages, concurrency, totalResults int, completedAt sql.NullTime) {
+	headers, _ := json.Marshal(res.Header)
+	redirects, _ := json.Marshal(res.Redirects)
+	if res.Redirects == nil {
+		redirects = []byte("[]")
+	}
+	requestHeaders, _ := json.Marshal(s.requestHeaderMap())
+	hash := bodyHash(res.Body)
+	stored, compression, err := database.CompressBody(res.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compress %s: %v\n", url, err)
+		stored, compression = res.Body, database.CompressionNone
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO html_blobs (hash, body, size, compression) VALUES ($1, $2, $3, $4) ON CONFLICT (hash) DO NOTHING`,
+		hash, stored, len(res.Body), compression,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+		return
+	}
+
+	_, err = tx.Exec(
 		`INSERT INTO raw_html 
-        (url, max_pages, concurrency, html, totalResults, completed_at) 
-        VALUES ($1, $2, $3, $4, $5, $6)`,
-		url, maxPages, concurrency, body, totalResults, completedAt,
+        (url, max_pages, concurrency, content_hash, totalResults, completed_at,
+         status_code, headers, content_type, final_url, redirect_chain, response_time_ms, byte_size,
+         request_headers, proxy, truncated, encoding, job_id, changed) 
+        VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()), $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+            $4 IS DISTINCT FROM (SELECT content_hash FROM raw_html WHERE url = $1 ORDER BY id DESC LIMIT 1))`,
+		url, maxPages, concurrency, hash, totalResults, completedAt,
+		res.StatusCode, headers, res.Header.Get("Content-Type"), res.FinalURL, redirects,
+		res.ResponseTime.Milliseconds(), len(res.Body),
+		requestHeaders, res.Proxy, res.Truncated, res.Encoding, s.job.jobID(),
 	)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+	}
+}
+
+/* skipped url db save */
+func (s *Scraper) saveSkippedURLToDB(url, reason string) {
+	_, err := s.DB.Exec(
+		`INSERT INTO skipped_urls (url, reason) VALUES ($1, $2)`,
+		url, reason,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+	}
+}
+
+/* fetch attempts db save */
+func (s *Scraper) saveAttemptsToDB(url string, attempts []Attempt) {
+	for _, a := range attempts {
+		_, err := s.DB.Exec(
+			`INSERT INTO fetch_attempts
+            (url, attempt, status_code, error, duration_ms, wait_ms, proxy)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			url, a.Number, a.StatusCode, a.Err, a.Duration.Milliseconds(), a.Wait.Milliseconds(), a.Proxy,
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "DB insert error: %v\n", err)
+		}
 	}
 }
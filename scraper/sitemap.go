@@ -0,0 +1,225 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapMaxBytes = 50 << 20 // protocol limit for an uncompressed sitemap
+	sitemapMaxFiles = 1000     // sitemaps read per site, indexes included
+)
+
+/* one <url> or <sitemap> entry */
+type SitemapEntry struct {
+	Loc     string
+	LastMod time.Time // zero when the sitemap doesn't say
+}
+
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+/* parse a urlset, a sitemap index or a plain text sitemap, gzipped or not */
+func ParseSitemap(r io.Reader) (urls, sitemaps []SitemapEntry, err error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sitemap gzip: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(io.LimitReader(gz, sitemapMaxBytes))
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		// text sitemap, one url per line
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "http") {
+				urls = append(urls, SitemapEntry{Loc: line})
+			}
+		}
+		return urls, nil, nil
+	}
+
+	var doc sitemapXML
+	if err := xml.Unmarshal(trimmed, &doc); err != nil {
+		return nil, nil, fmt.Errorf("sitemap xml: %w", err)
+	}
+	for _, u := range doc.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, SitemapEntry{Loc: loc, LastMod: parseLastMod(u.LastMod)})
+		}
+	}
+	for _, sm := range doc.Sitemaps {
+		if loc := strings.TrimSpace(sm.Loc); loc != "" {
+			sitemaps = append(sitemaps, SitemapEntry{Loc: loc, LastMod: parseLastMod(sm.LastMod)})
+		}
+	}
+	return urls, sitemaps, nil
+}
+
+/* W3C datetime: a date, or a date with time and zone */
+func parseLastMod(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+/* sitemaps of a site: robots.txt Sitemap lines, else /sitemap.xml */
+func (s *Scraper) DiscoverSitemaps(ctx context.Context, site *url.URL) []string {
+	if rules, err := s.Robots(ctx, site); err == nil && len(rules.Sitemaps) > 0 {
+		return rules.Sitemaps
+	}
+	return []string{site.Scheme + "://" + site.Host + "/sitemap.xml"}
+}
+
+/* page urls from the site's sitemaps, only those modified after since unless since is zero */
+func (s *Scraper) SitemapURLs(ctx context.Context, siteURL string, since time.Time) ([]string, error) {
+	site, err := url.Parse(siteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// sitemaps are bigger than pages and often served gzipped as octet-stream
+	sm := *s
+	sm.HeadCheck = false
+	sm.MaxBodyBytes = sitemapMaxBytes
+	sm.AllowedContentTypes = append(append([]string{}, s.AllowedContentTypes...),
+		"text/plain", "application/gzip", "application/x-gzip", "application/octet-stream")
+
+	queue := s.DiscoverSitemaps(ctx, site)
+	seen := make(map[string]bool)
+	var pages []string
+	for len(queue) > 0 && len(seen) < sitemapMaxFiles {
+		loc := queue[0]
+		queue = queue[1:]
+		if seen[loc] {
+			continue
+		}
+		seen[loc] = true
+
+		urls, children, err := sm.fetchSitemap(ctx, loc)
+		if err != nil {
+			if ctx.Err() != nil {
+				return pages, ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "sitemap %s: %v\n", loc, err)
+			continue
+		}
+		for _, child := range children {
+			// an index lastmod says when the child sitemap last changed
+			if since.IsZero() || child.LastMod.IsZero() || child.LastMod.After(since) {
+				queue = append(queue, child.Loc)
+			}
+		}
+		for _, u := range urls {
+			if since.IsZero() || u.LastMod.IsZero() || u.LastMod.After(since) {
+				pages = append(pages, u.Loc)
+			}
+		}
+	}
+	return pages, nil
+}
+
+func (s *Scraper) fetchSitemap(ctx context.Context, loc string) (urls, sitemaps []SitemapEntry, err error) {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	ok, reason, err := s.robotsAllowed(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, skipped("%s", reason)
+	}
+
+	res, err := s.fetch(ctx, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("status %d", res.StatusCode)
+	}
+	if res.Truncated {
+		return nil, nil, fmt.Errorf("larger than %d bytes", sitemapMaxBytes)
+	}
+	return ParseSitemap(bytes.NewReader(res.Body))
+}
+
+/* enqueue sitemap urls of the crawl's start site as depth 1 seeds */
+func (s *Scraper) SeedFromSitemaps(ctx context.Context, crawlID int, since time.Time) (int, error) {
+	f, startURL, _, err := s.openFrontier(crawlID)
+	if err != nil {
+		return 0, err
+	}
+	urls, err := s.SitemapURLs(ctx, startURL, since)
+	if err != nil {
+		return 0, err
+	}
+
+	var seeds []string
+	for _, raw := range urls {
+		if link, err := s.Canonicalizer.CanonicalizeString(raw); err == nil {
+			seeds = append(seeds, link)
+		}
+	}
+	if len(seeds) == 0 {
+		return 0, nil
+	}
+	results, err := f.pushAll(seeds, 1)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range results {
+		if r == pushed {
+			n++
+		}
+	}
+	return n, nil
+}
+
+/* end of the last finished crawl of this start url, for incremental sitemap crawls */
+func (s *Scraper) LastCrawled(startURL string) (time.Time, error) {
+	start, err := s.Canonicalizer.CanonicalizeString(startURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var last sql.NullTime
+	err = s.DB.QueryRow(
+		`SELECT MAX(updated_at) FROM crawls WHERE start_url = $1 AND state = $2`,
+		start, CrawlDone,
+	).Scan(&last)
+	return last.Time, err
+}
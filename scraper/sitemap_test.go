@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* entries equal with lastmod compared as instants */
+func sameEntries(got, want []SitemapEntry) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].Loc != want[i].Loc || !got[i].LastMod.Equal(want[i].LastMod) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseSitemap(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		urls     []SitemapEntry
+		sitemaps []SitemapEntry
+	}{
+		{"urlset", `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://example.com/a </loc><lastmod>2024-05-01</lastmod></url>
+  <url><loc>https://example.com/b</loc><lastmod>2024-05-01T10:30:00+02:00</lastmod></url>
+  <url><loc>https://example.com/c</loc><lastmod>yesterday</lastmod></url>
+  <url><loc></loc></url>
+</urlset>`, []SitemapEntry{
+			{Loc: "https://example.com/a", LastMod: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+			{Loc: "https://example.com/b", LastMod: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)},
+			{Loc: "https://example.com/c"},
+		}, nil},
+		{"index", `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://example.com/posts.xml</loc><lastmod>2024-05</lastmod></sitemap>
+  <sitemap><loc>https://example.com/pages.xml.gz</loc></sitemap>
+</sitemapindex>`, nil, []SitemapEntry{
+			{Loc: "https://example.com/posts.xml", LastMod: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+			{Loc: "https://example.com/pages.xml.gz"},
+		}},
+		{"text", "\xef\xbb\xbfhttps://example.com/a\r\n\n# comment\nhttp://example.com/b\n", []SitemapEntry{
+			{Loc: "https://example.com/a"},
+			{Loc: "http://example.com/b"},
+		}, nil},
+	}
+	for _, tt := range tests {
+		urls, sitemaps, err := ParseSitemap(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameEntries(urls, tt.urls) || !sameEntries(sitemaps, tt.sitemaps) {
+			t.Errorf("%s: got %v, %v\nwant %v, %v", tt.name, urls, sitemaps, tt.urls, tt.sitemaps)
+		}
+	}
+}
+
+func TestParseSitemapGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`<urlset><url><loc>https://example.com/a</loc></url></urlset>`))
+	gz.Close()
+
+	urls, _, err := ParseSitemap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SitemapEntry{{Loc: "https://example.com/a"}}; !reflect.DeepEqual(urls, want) {
+		t.Fatalf("got %v, want %v", urls, want)
+	}
+}
+
+func TestParseSitemapBadXML(t *testing.T) {
+	if _, _, err := ParseSitemap(strings.NewReader("<urlset><url>")); err == nil {
+		t.Fatal("truncated xml parsed")
+	}
+}
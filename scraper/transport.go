@@ -0,0 +1,137 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* tuning of the shared http transport */
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int // 0 means no limit
+	IdleConnTimeout       time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	DialTimeout           time.Duration
+	TCPKeepAlive          time.Duration
+	DisableKeepAlives     bool
+	ForceHTTP2            bool
+	DNSCacheTTL           time.Duration // 0 disables the dns cache
+}
+
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		MaxConnsPerHost:       0,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		DialTimeout:           10 * time.Second,
+		TCPKeepAlive:          30 * time.Second,
+		ForceHTTP2:            true,
+		DNSCacheTTL:           5 * time.Minute,
+	}
+}
+
+/* transport with keep-alives, per host limits and dns cache */
+func NewTransport(cfg TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.TCPKeepAlive,
+	}
+	dial := dialer.DialContext
+	if cfg.DNSCacheTTL > 0 {
+		dial = newDNSCache(cfg.DNSCacheTTL).dialer(dialer)
+	}
+
+	return &http.Transport{
+		Proxy:                 proxyFromContext,
+		DialContext:           dial,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		// a custom DialContext turns http2 off unless forced
+		ForceAttemptHTTP2: cfg.ForceHTTP2,
+	}
+}
+
+/* swap the shared transport, jobs created afterwards use it */
+func (s *Scraper) SetTransport(cfg TransportConfig) {
+	if old, ok := s.client.Transport.(*http.Transport); ok {
+		old.CloseIdleConnections()
+	}
+	s.client.Transport = NewTransport(cfg)
+}
+
+/* resolved addresses per host */
+type dnsCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	resolver *net.Resolver
+	entries  map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration) *dnsCache {
+	return &dnsCache{ttl: ttl, resolver: net.DefaultResolver, entries: make(map[string]dnsEntry)}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+/* DialContext that resolves through the cache and tries each address */
+func (c *dnsCache) dialer(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return d.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		// addresses may be stale, resolve again next time
+		c.mu.Lock()
+		delete(c.entries, host)
+		c.mu.Unlock()
+		return nil, errors.Join(errs...)
+	}
+}
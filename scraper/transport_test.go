@@ -0,0 +1,56 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const benchPages = 20
+
+/* listing served as ?page=N, counts the connections opened to it */
+func listingServer(b *testing.B) (*httptest.Server, *atomic.Int64) {
+	conns := &atomic.Int64{}
+	body := "<html><head><title>listing</title></head><body>" + strings.Repeat("<p>item</p>", 1000) + "</body></html>"
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<!-- page %s -->%s", r.URL.Query().Get("page"), body)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	b.Cleanup(srv.Close)
+	return srv, conns
+}
+
+/* scrape benchPages listing pages per op with keep-alives on or off */
+func benchmarkScrape(b *testing.B, keepAlive bool) {
+	srv, conns := listingServer(b)
+	s := NewScraper(4, 5, "bench", stubDB())
+	s.RespectRobots = false
+	s.Limiter = NewHostLimiter(RateLimit{})
+	cfg := DefaultTransportConfig()
+	cfg.DisableKeepAlives = !keepAlive
+	s.SetTransport(cfg)
+	b.Cleanup(s.Pool.Close)
+
+	b.ResetTimer()
+	for range b.N {
+		s.Scrape(context.Background(), srv.URL+"/list", benchPages)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+func BenchmarkScrapeKeepAlive(b *testing.B) {
+	b.Run("on", func(b *testing.B) { benchmarkScrape(b, true) })
+	b.Run("off", func(b *testing.B) { benchmarkScrape(b, false) })
+}
@@ -0,0 +1,132 @@
+package scraper
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+/* url normalization used for fetching and the visited set */
+type Canonicalizer struct {
+	StripParams []string // query params dropped, a trailing '*' matches a prefix
+}
+
+func DefaultCanonicalizer() Canonicalizer {
+	return Canonicalizer{
+		StripParams: []string{
+			"utm_*", "gclid", "fbclid", "msclkid", "mc_cid", "mc_eid",
+			"sessionid", "session_id", "phpsessid", "jsessionid", "sid",
+		},
+	}
+}
+
+func (c Canonicalizer) stripped(param string) bool {
+	param = strings.ToLower(param)
+	for _, p := range c.StripParams {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(param, prefix) {
+				return true
+			}
+		} else if param == p {
+			return true
+		}
+	}
+	return false
+}
+
+/* lowercase scheme/host, no default port, no fragment, sorted and filtered query */
+func (c Canonicalizer) Canonicalize(u *url.URL) (string, error) {
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return "", fmt.Errorf("missing host in %q", u.String())
+	}
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// ipv6 literal
+		host = "[" + host + "]"
+	}
+
+	out := &url.URL{Scheme: scheme, Host: host, Path: u.Path, RawPath: u.RawPath}
+	if out.Path == "" {
+		out.Path = "/"
+	}
+	// ;jsessionid=... style path parameters
+	if i := strings.Index(strings.ToLower(out.Path), ";jsessionid="); i >= 0 {
+		out.Path = out.Path[:i]
+		out.RawPath = ""
+	}
+
+	if u.RawQuery != "" {
+		values, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			// ';' separators or bad escapes, re-encoding would change the query
+			out.RawQuery = u.RawQuery
+			return out.String(), nil
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			if !c.stripped(k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var parts []string
+		for _, k := range keys {
+			for _, v := range values[k] {
+				parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+			}
+		}
+		out.RawQuery = strings.Join(parts, "&")
+	}
+	return out.String(), nil
+}
+
+/* parse and canonicalize a raw url */
+func (c Canonicalizer) CanonicalizeString(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	return c.Canonicalize(u)
+}
+
+/* links of a page resolved against the page url / <base href>, plus rel=canonical */
+func (c Canonicalizer) docLinks(doc *goquery.Document, pageURL *url.URL) ([]string, string) {
+	base := pageURL
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if b, err := pageURL.Parse(strings.TrimSpace(href)); err == nil {
+			base = b
+		}
+	}
+	resolve := func(href string) (string, bool) {
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return "", false
+		}
+		canonical, err := c.Canonicalize(u)
+		return canonical, err == nil
+	}
+
+	var links []string
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		if link, ok := resolve(s.AttrOr("href", "")); ok {
+			links = append(links, link)
+		}
+	})
+
+	canonical := ""
+	if href, ok := doc.Find("link[rel=canonical]").First().Attr("href"); ok {
+		canonical, _ = resolve(href)
+	}
+	return links, canonical
+}
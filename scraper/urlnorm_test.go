@@ -0,0 +1,44 @@
+package scraper
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"HTTP://Example.COM", "http://example.com/"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"http://example.com/a#top", "http://example.com/a"},
+		{"http://example.com/?b=2&a=1&a=0", "http://example.com/?a=1&a=0&b=2"},
+		{"http://example.com/?utm_source=x&id=1&gclid=y", "http://example.com/?id=1"},
+		{"http://example.com/?utm_source=x", "http://example.com/"},
+		{"http://example.com/a;jsessionid=ABC?x=1", "http://example.com/a?x=1"},
+		{"http://example.com/?a=1;b=2", "http://example.com/?a=1;b=2"},
+		{"http://example.com/a%2Fb", "http://example.com/a%2Fb"},
+		{"http://[::1]:8080/a", "http://[::1]:8080/a"},
+		{"http://[::1]/a", "http://[::1]/a"},
+		{"https://[2001:DB8::1]:443/", "https://[2001:db8::1]/"},
+	}
+	c := DefaultCanonicalizer()
+	for _, tt := range tests {
+		got, err := c.CanonicalizeString(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalizeRejects(t *testing.T) {
+	c := DefaultCanonicalizer()
+	for _, in := range []string{"ftp://example.com/", "mailto:a@example.com", "http:///path"} {
+		if got, err := c.CanonicalizeString(in); err == nil {
+			t.Errorf("%s: got %s, want an error", in, got)
+		}
+	}
+}
@@ -1,58 +1,281 @@
 package scraper
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"time"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"webScraper/parser"
+
+	"github.com/PuerkitoBio/goquery"
 )
 
-/*  workers with routines and channels */
-func worker(id int, jobs <-chan int, results chan<- int, abort <-chan struct{}) {
-    for {
-        select {
-        case j, ok := <-jobs:
-            if !ok {
-                return 
-            }
-            ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-            done := make(chan struct{})
-            go func() {
-                fmt.Println("worker", id, "started job", j)
-                fmt.Println("worker", id, "finished job", j)
-                results <- j * 2
-                close(done)
-            }()
-            select {
-            case <-done:
-            case <-ctx.Done():
-                fmt.Println("worker", id, "timeout for job", j)
-            }
-            cancel()
-        case <-abort:
-            fmt.Println("worker", id, "aborted")
-            return
-        }
-    }
-}
-
-/* start workers, deal jobs and collect results */
-func StartWorkerPool(numWorkers, numJobs int, abort <-chan struct{}) {
-	jobs := make(chan int, numJobs)
-	results := make(chan int, numJobs)
-
-	for w := 1; w <= numWorkers; w++ {
-		go worker(w, jobs, results, abort)
-	}
-
-	for j := 1; j <= numJobs; j++ {
-		jobs <- j
-	}
-	close(jobs)
-
-	for a := 1; a <= numJobs; a++ {
-		res := <-results
-		fmt.Println("Results", res)
-	}
-
-	fmt.Println("All jobs done. Shutting down worker pool")
+var ErrPoolClosed = errors.New("worker pool closed")
+
+/* bounded executor for page tasks shared by all jobs, waiting tasks don't hold a slot */
+type Pool struct {
+	workers int
+	slots   chan struct{}
+	queue   chan poolItem
+	done    chan struct{}
+	mu      sync.RWMutex
+	closed  bool
+	start   sync.Once
+	stop    sync.Once
+	wg      sync.WaitGroup
+
+	queued    atomic.Int64
+	running   atomic.Int64
+	waiting   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	timedOut  atomic.Int64
+	canceled  atomic.Int64
+}
+
+type poolItem struct {
+	ctx  context.Context
+	task Task
+}
+
+/* snapshot of pool counters */
+type PoolMetrics struct {
+	Workers   int   `json:"workers"`
+	Queued    int64 `json:"queued"`
+	Running   int64 `json:"running"`
+	Waiting   int64 `json:"waiting"` // started, blocked without a slot
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	TimedOut  int64 `json:"timed_out"`
+	Canceled  int64 `json:"canceled"`
+}
+
+/* pool of workers, queueSize 0 means one slot per worker; the dispatcher starts on first Submit */
+func NewPool(workers, queueSize int) *Pool {
+	workers = max(workers, 1)
+	if queueSize <= 0 {
+		queueSize = workers
+	}
+	return &Pool{
+		workers: workers,
+		slots:   make(chan struct{}, workers),
+		queue:   make(chan poolItem, queueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+/* queue a task, blocks while the queue is full; the task runs with ctx */
+func (p *Pool) Submit(ctx context.Context, t Task) error {
+	p.start.Do(func() {
+		p.wg.Add(1)
+		go p.dispatch()
+	})
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	select {
+	case p.queue <- poolItem{ctx: ctx, task: t}:
+		p.queued.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return ErrPoolClosed
+	}
+}
+
+/* stop accepting tasks, run what is queued and wait for the tasks */
+func (p *Pool) Close() {
+	p.stop.Do(func() {
+		// unblock Submits waiting for room before taking the write lock
+		close(p.done)
+		p.mu.Lock()
+		p.closed = true
+		close(p.queue)
+		p.mu.Unlock()
+	})
+	p.wg.Wait()
+}
+
+func (p *Pool) Metrics() PoolMetrics {
+	return PoolMetrics{
+		Workers:   p.workers,
+		Queued:    p.queued.Load(),
+		Running:   p.running.Load(),
+		Waiting:   p.waiting.Load(),
+		Completed: p.completed.Load(),
+		Failed:    p.failed.Load(),
+		TimedOut:  p.timedOut.Load(),
+		Canceled:  p.canceled.Load(),
+	}
+}
+
+/* start queued tasks as slots free up */
+func (p *Pool) dispatch() {
+	defer p.wg.Done()
+	for item := range p.queue {
+		p.slots <- struct{}{}
+		p.queued.Add(-1)
+		p.running.Add(1)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			slot := &poolSlot{pool: p, held: true}
+			item.ctx = context.WithValue(item.ctx, poolSlotKey{}, slot)
+			result := p.run(item)
+			slot.release()
+
+			if item.task.Callback != nil {
+				item.task.Callback(result)
+			}
+		}()
+	}
+}
+
+/* the worker slot a running task holds */
+type poolSlot struct {
+	pool *Pool
+	held bool
+}
+
+type poolSlotKey struct{}
+
+func (s *poolSlot) release() {
+	if s.held {
+		s.held = false
+		s.pool.running.Add(-1)
+		<-s.pool.slots
+	}
+}
+
+func (s *poolSlot) acquire(ctx context.Context) error {
+	select {
+	case s.pool.slots <- struct{}{}:
+		s.held = true
+		s.pool.running.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* run a blocking wait without holding a pool slot, so other jobs' tasks can run meanwhile */
+func idleWait(ctx context.Context, wait func() error) error {
+	slot, ok := ctx.Value(poolSlotKey{}).(*poolSlot)
+	if !ok || !slot.held {
+		return wait()
+	}
+	slot.release()
+	slot.pool.waiting.Add(1)
+	err := wait()
+	slot.pool.waiting.Add(-1)
+	if err := slot.acquire(ctx); err != nil {
+		return err
+	}
+	return err
+}
+
+/* fetch and parse one page within the task's timeout */
+func (p *Pool) run(item poolItem) Result {
+	t := item.task
+	if err := item.ctx.Err(); err != nil {
+		p.canceled.Add(1)
+		return Result{URL: t.URL, Error: err}
+	}
+	if t.Scraper == nil {
+		p.failed.Add(1)
+		return Result{URL: t.URL, Error: fmt.Errorf("task %s has no scraper", t.URL)}
+	}
+
+	ctx := item.ctx
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	result := t.Scraper.runTask(ctx, t)
+	switch {
+	case result.Error == nil:
+		p.completed.Add(1)
+	case item.ctx.Err() != nil:
+		p.canceled.Add(1)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		p.timedOut.Add(1)
+	default:
+		p.failed.Add(1)
+	}
+	return result
+}
+
+/* fetch, store and parse a task's page */
+func (s *Scraper) runTask(ctx context.Context, t Task) Result {
+	result := Result{URL: t.URL}
+	res, err := s.fetchPage(ctx, t.URL, max(t.MaxPages, 1), 0, sql.NullTime{Valid: false})
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.StatusCode = res.StatusCode
+	result.RawHTML = res.Body
+	result.FinalURL = res.FinalURL
+	result.Encoding = res.Encoding
+
+	pageURL, err := url.Parse(res.FinalURL)
+	if err != nil {
+		return result
+	}
+	html := res.Body
+	if utf8HTML, err := parser.ToUTF8(html, res.Encoding); err == nil {
+		html = utf8HTML
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return result
+	}
+
+	result.Title = strings.TrimSpace(doc.Find("title").First().Text())
+	result.Metadata = make(map[string]string)
+	doc.Find("meta[content]").Each(func(i int, m *goquery.Selection) {
+		name := m.AttrOr("name", m.AttrOr("property", ""))
+		if name != "" {
+			result.Metadata[strings.ToLower(name)] = m.AttrOr("content", "")
+		}
+	})
+	result.Description = result.Metadata["description"]
+	result.Links, result.Canonical = s.Canonicalizer.docLinks(doc, pageURL)
+	return result
+}
+
+/* task fetched with this scraper's job settings */
+func (s *Scraper) task(t Task) Task {
+	t.Scraper = s
+	if t.Timeout == 0 {
+		t.Timeout = s.Options.TaskTimeout
+	}
+	return t
+}
+
+/* run a task on the pool and wait for its result */
+func (s *Scraper) doTask(ctx context.Context, t Task) Result {
+	ch := make(chan Result, 1)
+	t.Callback = func(r Result) { ch <- r }
+	if err := s.Pool.Submit(ctx, s.task(t)); err != nil {
+		return Result{URL: t.URL, Error: err}
+	}
+	select {
+	case r := <-ch:
+		return r
+	case <-ctx.Done():
+		return Result{URL: t.URL, Error: ctx.Err()}
+	}
 }